@@ -0,0 +1,262 @@
+package latigo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/latifrons/latigo/boot"
+	"github.com/latifrons/latigo/cron"
+	"github.com/latifrons/latigo/program"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// Config keys of the command line flags. A flag overrides <EnvPrefix>_<KEY>, which overrides the config files.
+const (
+	KeyLogLevel   = "log_level"
+	KeyDumpConfig = "dump_config"
+	KeyDryRun     = "dry_run"
+)
+
+// ConfigWatchDebounce is how long run waits for config file changes to settle with --watch-config.
+const ConfigWatchDebounce = time.Second
+
+// Flags that are not config keys. They override <EnvPrefix>_ROOT_DIR, <EnvPrefix>_CONFIG_DIR and
+// <EnvPrefix>_PROFILE, which override Folders.
+const (
+	FlagRootDir   = "root-dir"
+	FlagConfigDir = "config-dir"
+	FlagProfile   = "profile"
+	// FlagWatchConfig makes run reload the config when its files change. It always reloads on SIGHUP.
+	FlagWatchConfig = "watch-config"
+)
+
+// Command returns the command line of the engine:
+//
+//	<name> [run]           start the engine, the default
+//	<name> config dump     print the config with the source of every key
+//	<name> config validate load the config and configure the components without starting them
+//	<name> jobs list       list the boot sequence
+//
+// with the flags --root-dir, --config-dir, --profile, --log-level, --dump-config, --dry-run, --watch-config and
+// --version.
+// Name, Version, Folders, LogLevel and DumpConfigOnStart are the defaults of the flags. Binaries may add their own
+// subcommands.
+func (b *EngineV2) Command() *cobra.Command {
+	version := b.Version
+	if version == "" {
+		version = "unknown"
+	}
+	root := &cobra.Command{
+		Use:           b.Name,
+		Version:       version,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.NoArgs,
+		RunE:          b.runCommand,
+	}
+	flags := root.PersistentFlags()
+	flags.String(FlagRootDir, b.Folders.Root, "root folder of log, data, config and private")
+	flags.String(FlagConfigDir, b.Folders.Config, "config folder, relative to the root folder")
+	flags.String(FlagProfile, "", "config profile, e.g. dev, staging or prod")
+	flags.String("log-level", b.LogLevel, "log level: trace, debug, info, warn or error")
+	flags.Bool("dump-config", b.DumpConfigOnStart, "log the config on start")
+	flags.Bool("dry-run", false, "load the config and configure the components, then exit")
+	flags.Bool(FlagWatchConfig, false, "reload the config when its files change, not only on SIGHUP")
+
+	root.AddCommand(&cobra.Command{
+		Use:   "run",
+		Short: "Start the engine",
+		Args:  cobra.NoArgs,
+		RunE:  b.runCommand,
+	})
+
+	configCommand := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the config",
+	}
+	configCommand.AddCommand(&cobra.Command{
+		Use:   "dump",
+		Short: "Print the config with the source of every key, secrets redacted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := b.loadCommandConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			b.config().Dump(program.WithSources())
+			return nil
+		},
+	})
+	configCommand.AddCommand(&cobra.Command{
+		Use:   "validate",
+		Short: "Load the config and configure the components without starting them",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := b.loadCommandConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			err = b.Check()
+			if err != nil {
+				return err
+			}
+			log.Info().Str("name", b.Name).Msg("config is valid")
+			return nil
+		},
+	})
+	root.AddCommand(configCommand)
+
+	jobsCommand := &cobra.Command{
+		Use:   "jobs",
+		Short: "Inspect the boot sequence",
+	}
+	jobsCommand.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the boot jobs, components and cron jobs in boot order",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return b.listJobs(cmd)
+		},
+	})
+	root.AddCommand(jobsCommand)
+	return root
+}
+
+// Execute runs the command line and exits when it fails, with ExitCodeConfig if the config is unusable.
+func (b *EngineV2) Execute() {
+	err := b.Command().Execute()
+	if err == nil {
+		return
+	}
+	log.Error().Str("name", b.Name).Msg(err.Error())
+	if isConfigError(err) {
+		os.Exit(ExitCodeConfig)
+	}
+	os.Exit(1)
+}
+
+func isConfigError(err error) bool {
+	var folderError *program.FolderError
+	var fileError *program.ConfigFileError
+	var configErrors program.ConfigErrors
+	var configError program.ConfigError
+	return errors.As(err, &folderError) || errors.As(err, &fileError) || errors.As(err, &configErrors) ||
+		errors.As(err, &configError)
+}
+
+func (b *EngineV2) runCommand(cmd *cobra.Command, args []string) error {
+	err := b.loadCommandConfig(cmd.Flags())
+	if err != nil {
+		return err
+	}
+	cfg := b.config()
+	b.DumpConfigOnStart = cfg.GetBool(KeyDumpConfig)
+	if b.DumpConfigOnStart {
+		cfg.Dump()
+	}
+	if cfg.GetBool(KeyDryRun) {
+		err = b.Check()
+		if err != nil {
+			return err
+		}
+		log.Info().Str("name", b.Name).Msg("dry run, not starting")
+		return nil
+	}
+	var opts []program.ReloadOption
+	if watch, _ := cmd.Flags().GetBool(FlagWatchConfig); watch {
+		opts = append(opts, program.WithWatch(ConfigWatchDebounce))
+	}
+	b.EnableConfigReload(opts...)
+	b.Start()
+	return nil
+}
+
+// loadCommandConfig binds the flags into the global config, loads it from the folders of the flags and applies
+// the log level.
+func (b *EngineV2) loadCommandConfig(flags *pflag.FlagSet) error {
+	folders := b.Folders
+	folders.Root = flagOrEnv(flags, FlagRootDir, b.EnvPrefix, "root_dir")
+	folders.Config = flagOrEnv(flags, FlagConfigDir, b.EnvPrefix, "config_dir")
+	profile := flagOrEnv(flags, FlagProfile, b.EnvPrefix, "profile")
+
+	// bind first, so a given flag wins over every value loaded, secrets included
+	b.Config = program.GlobalConfig()
+	for key, flag := range map[string]string{
+		KeyLogLevel:   "log-level",
+		KeyDumpConfig: "dump-config",
+		KeyDryRun:     "dry-run",
+	} {
+		err := b.Config.BindFlag(key, flags.Lookup(flag))
+		if err != nil {
+			return err
+		}
+	}
+
+	folders, err := program.LoadConfigsWithProfileE(folders, b.EnvPrefix, profile)
+	if err != nil {
+		return err
+	}
+	b.Folders = folders
+	return b.applyLogLevel()
+}
+
+// flagOrEnv returns the flag if given, else <envPrefix>_<KEY>, else the default of the flag.
+func flagOrEnv(flags *pflag.FlagSet, name string, envPrefix string, key string) string {
+	flag := flags.Lookup(name)
+	if flag.Changed {
+		return flag.Value.String()
+	}
+	if value, ok := os.LookupEnv(program.EnvKey(envPrefix, key)); ok {
+		return value
+	}
+	return flag.Value.String()
+}
+
+// Check configures the components implementing program.Configurable without starting anything and returns all
+// failures.
+func (b *EngineV2) Check() error {
+	var errs []error
+	for _, job := range b.Jobs {
+		if job.Type != BootTypeComponent {
+			continue
+		}
+		component := job.Job.(program.Component)
+		if configurable, ok := component.(program.Configurable); ok {
+			err := configurable.Configure(b.config())
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", component.Name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *EngineV2) listJobs(cmd *cobra.Command) error {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tNAME\tSCHEDULE")
+	for _, job := range b.Jobs {
+		switch job.Type {
+		case BootTypeOnce:
+			bootJob := job.Job.(boot.BootJob)
+			schedule := ""
+			if bootJob.FaultTolerant {
+				schedule = "fault tolerant"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", job.Type, bootJob.Name, schedule)
+		case BootTypeComponent:
+			fmt.Fprintf(w, "%s\t%s\t\n", job.Type, job.Job.(program.Component).Name())
+		case BootTypeCron:
+			cronJob := job.Job.(cron.CronJob)
+			schedule := cronJob.Cron
+			if schedule == "" {
+				schedule = "every " + cronJob.Interval.String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", job.Type, cronJob.Name, schedule)
+		}
+	}
+	return w.Flush()
+}
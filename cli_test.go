@@ -0,0 +1,167 @@
+package latigo
+
+import (
+	"bytes"
+	"errors"
+	"github.com/latifrons/latigo/boot"
+	"github.com/latifrons/latigo/cron"
+	"github.com/latifrons/latigo/program"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testComponent struct {
+	name       string
+	err        error
+	configured int
+	started    int
+}
+
+func (c *testComponent) Configure(cfg *program.Config) error {
+	c.configured++
+	return c.err
+}
+
+func (c *testComponent) Start() {
+	c.started++
+}
+
+func (c *testComponent) Stop() {}
+
+func (c *testComponent) Name() string {
+	return c.name
+}
+
+// newTestEngine returns an engine rooted in a temporary folder holding config.toml with content.
+func newTestEngine(t *testing.T, content string) *EngineV2 {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "config"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "config", "config.toml"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(viper.Reset)
+	return &EngineV2{
+		Name:      "test",
+		EnvPrefix: "CLITEST",
+		Folders:   program.FolderConfig{Root: root},
+	}
+}
+
+// execute runs the command line of b and returns its output and the log lines.
+func execute(t *testing.T, b *EngineV2, args ...string) (string, string, error) {
+	t.Helper()
+	var logs bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&logs)
+	t.Cleanup(func() { log.Logger = logger })
+
+	var out bytes.Buffer
+	cmd := b.Command()
+	cmd.SetArgs(args)
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	err := cmd.Execute()
+	return out.String(), logs.String(), err
+}
+
+func TestRunDryRun(t *testing.T) {
+	b := newTestEngine(t, "")
+	component := &testComponent{name: "api"}
+	b.AddComponent(component)
+
+	_, logs, err := execute(t, b, "run", "--dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if component.configured != 1 || component.started != 0 {
+		t.Errorf("component configured %d and started %d times, want 1 and 0", component.configured, component.started)
+	}
+	if !strings.Contains(logs, "dry run, not starting") {
+		t.Errorf("logs %q do not report the dry run", logs)
+	}
+}
+
+func TestConfigDump(t *testing.T) {
+	t.Setenv("CLITEST_DB_PASSWORD", "s3cr3t")
+	b := newTestEngine(t, "db_password = \"env:CLITEST_DB_PASSWORD\"\nlog_level = \"env:CLITEST_DB_PASSWORD\"\n[server]\nurl = \"http://localhost\"\n")
+
+	// log_level holds a secret reference, the flag still overrides it
+	_, logs, err := execute(t, b, "config", "dump", "--log-level", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logs, "s3cr3t") {
+		t.Errorf("dump %s shows the secret", logs)
+	}
+	for _, want := range []string{`"server.url":{"source":"config.toml","value":"http://localhost"}`, `"source":"flag:--log-level"`} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("dump %s does not contain %s", logs, want)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	b := newTestEngine(t, "")
+	b.AddComponent(&testComponent{name: "api"})
+	_, logs, err := execute(t, b, "config", "validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs, "config is valid") {
+		t.Errorf("logs %q do not report a valid config", logs)
+	}
+
+	b = newTestEngine(t, "")
+	failure := errors.New("url is required")
+	b.AddComponent(&testComponent{name: "db", err: failure})
+	_, _, err = execute(t, b, "config", "validate")
+	if !errors.Is(err, failure) || !strings.Contains(err.Error(), "db: ") {
+		t.Errorf("validate with a failing component: %v, want the failure named after the component", err)
+	}
+
+	b = newTestEngine(t, "log_level = \"loud\"\n")
+	_, _, err = execute(t, b, "config", "validate")
+	if !isConfigError(err) {
+		t.Errorf("validate with an invalid log level: %v, want a config error", err)
+	}
+}
+
+func TestJobsList(t *testing.T) {
+	b := newTestEngine(t, "")
+	b.Jobs = []BootSequence{
+		{Type: BootTypeOnce, Job: boot.BootJob{Name: "migrate", FaultTolerant: true}},
+		{Type: BootTypeComponent, Job: &testComponent{name: "api"}},
+		{Type: BootTypeCron, Job: cron.CronJob{Name: "cleanup", Interval: time.Minute}},
+		{Type: BootTypeCron, Job: cron.CronJob{Name: "report", Cron: "0 0 * * * *"}},
+	}
+
+	out, _, err := execute(t, b, "jobs", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	want := [][]string{
+		{"TYPE", "NAME", "SCHEDULE"},
+		{"once", "migrate", "fault", "tolerant"},
+		{"component", "api"},
+		{"cron", "cleanup", "every", "1m0s"},
+		{"cron", "report", "0", "0", "*", "*", "*", "*"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("jobs list:\n%s\nwant %d lines", out, len(want))
+	}
+	for i, line := range lines {
+		if got := strings.Fields(line); strings.Join(got, " ") != strings.Join(want[i], " ") {
+			t.Errorf("line %d = %q, want %q", i, line, strings.Join(want[i], " "))
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package cron
+
+import (
+	"fmt"
+	robfigcron "github.com/robfig/cron/v3"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the time source of a CronService.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// RealClock returns the wall clock. It is the default when no Clock is injected.
+func RealClock() Clock {
+	return realClock{}
+}
+
+// same parser gocron uses for CronWithSeconds
+var cronParser = robfigcron.NewParser(robfigcron.Second | robfigcron.Minute | robfigcron.Hour | robfigcron.Dom | robfigcron.Month | robfigcron.Dow | robfigcron.Descriptor)
+
+// FakeClock is a manually driven Clock for tests.
+// Jobs scheduled on a FakeClock never fire on their own; Advance fires every due job synchronously,
+// in schedule order, on the calling goroutine.
+//
+// A job that calls Advance itself simulates a long run: jobs that become due meanwhile fire from within,
+// and the singleton guard skips overlapping runs of the same job.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	seq    uint64
+}
+
+type fakeTimer struct {
+	scheduler *fakeScheduler
+	entry     *cronEntry
+	next      time.Time
+	interval  time.Duration
+	cron      robfigcron.Schedule
+	seq       uint64
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{
+		now: start,
+	}
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the clock forward by d, firing each job whose run is due on the way.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	target := f.now.Add(d)
+	f.mu.Unlock()
+
+	for {
+		f.mu.Lock()
+		timer := f.nextDue(target)
+		if timer == nil {
+			if target.After(f.now) {
+				f.now = target
+			}
+			f.mu.Unlock()
+			return
+		}
+		if timer.next.After(f.now) {
+			f.now = timer.next
+		}
+		if timer.cron != nil {
+			timer.next = timer.cron.Next(f.now)
+		} else {
+			timer.next = timer.next.Add(timer.interval)
+		}
+		f.seq++
+		timer.seq = f.seq
+		entry := timer.entry
+		f.mu.Unlock()
+
+		entry.run()
+	}
+}
+
+// nextDue returns the earliest timer of a started scheduler due at or before target.
+func (f *FakeClock) nextDue(target time.Time) *fakeTimer {
+	var due []*fakeTimer
+	for _, timer := range f.timers {
+		if timer.scheduler.started && !timer.next.After(target) {
+			due = append(due, timer)
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].next.Equal(due[j].next) {
+			return due[i].seq < due[j].seq
+		}
+		return due[i].next.Before(due[j].next)
+	})
+	return due[0]
+}
+
+func (f *FakeClock) newScheduler() jobScheduler {
+	return &fakeScheduler{clock: f}
+}
+
+type fakeScheduler struct {
+	clock   *FakeClock
+	started bool
+}
+
+func (s *fakeScheduler) schedule(entry *cronEntry) error {
+	f := s.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	timer := &fakeTimer{
+		scheduler: s,
+		entry:     entry,
+	}
+	job := entry.job
+	if job.Type == CronJobTypeCron {
+		schedule, err := cronParser.Parse(job.Cron)
+		if err != nil {
+			return err
+		}
+		timer.cron = schedule
+		timer.next = schedule.Next(f.now.In(time.UTC))
+	} else {
+		if job.Interval <= 0 {
+			return fmt.Errorf("invalid interval %s for cron job %s", job.Interval, job.Name)
+		}
+		timer.interval = job.Interval
+		if job.WaitForSchedule {
+			timer.next = f.now.Add(job.Interval)
+		} else {
+			timer.next = f.now
+		}
+	}
+	f.seq++
+	timer.seq = f.seq
+	f.timers = append(f.timers, timer)
+	return nil
+}
+
+func (s *fakeScheduler) unschedule(entry *cronEntry) {
+	s.clock.mu.Lock()
+	defer s.clock.mu.Unlock()
+	s.clock.removeTimers(func(timer *fakeTimer) bool {
+		return timer.scheduler == s && timer.entry == entry
+	})
+}
+
+func (s *fakeScheduler) nextRun(entry *cronEntry) time.Time {
+	s.clock.mu.Lock()
+	defer s.clock.mu.Unlock()
+	for _, timer := range s.clock.timers {
+		if timer.scheduler == s && timer.entry == entry {
+			return timer.next
+		}
+	}
+	return time.Time{}
+}
+
+func (s *fakeScheduler) start() {
+	s.clock.mu.Lock()
+	s.started = true
+	s.clock.mu.Unlock()
+}
+
+func (s *fakeScheduler) stop() {
+	s.clock.mu.Lock()
+	defer s.clock.mu.Unlock()
+	s.started = false
+	s.clock.removeTimers(func(timer *fakeTimer) bool {
+		return timer.scheduler == s
+	})
+}
+
+func (f *FakeClock) removeTimers(match func(timer *fakeTimer) bool) {
+	kept := f.timers[:0]
+	for _, timer := range f.timers {
+		if !match(timer) {
+			kept = append(kept, timer)
+		}
+	}
+	f.timers = kept
+}
@@ -0,0 +1,283 @@
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrJobNotFound = errors.New("cron job not found")
+var ErrJobRunning = errors.New("cron job is already running")
+var ErrNotStarted = errors.New("cron service not started")
+
+// JobInfo is a snapshot of a registered cron job, suitable for admin endpoints.
+type JobInfo struct {
+	Name             string        `json:"name"`
+	Type             string        `json:"type"`
+	Cron             string        `json:"cron,omitempty"`
+	Interval         time.Duration `json:"interval,omitempty"`
+	DisableSingleton bool          `json:"disableSingleton"`
+	Paused           bool          `json:"paused"`
+	Running          int           `json:"running"`
+	RunCount         int64         `json:"runCount"`
+	SkipCount        int64         `json:"skipCount"`
+	NextRun          time.Time     `json:"nextRun"`
+	LastRun          time.Time     `json:"lastRun"`
+}
+
+// JobController is the runtime control surface of a scheduler.
+// It is implemented by CronService and meant to back admin HTTP or gRPC endpoints.
+type JobController interface {
+	ListJobs() []JobInfo
+	PauseJob(name string) error
+	ResumeJob(name string) error
+	TriggerJob(name string) error
+	RescheduleCron(name string, cronExpression string) error
+	RescheduleInterval(name string, interval time.Duration) error
+}
+
+var _ JobController = (*CronService)(nil)
+
+type cronEntry struct {
+	job   CronJob
+	clock Clock
+
+	mu        sync.Mutex
+	paused    bool
+	running   int
+	runCount  int64
+	skipCount int64
+	lastRun   time.Time
+}
+
+func newCronEntry(job CronJob, clock Clock) *cronEntry {
+	return &cronEntry{
+		job:   job,
+		clock: clock,
+	}
+}
+
+// acquire reserves a run slot, honouring the pause flag and the singleton policy.
+func (e *cronEntry) acquire(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.paused || (!e.job.DisableSingleton && e.running > 0) {
+		e.skipCount++
+		return false
+	}
+	e.running++
+	e.runCount++
+	e.lastRun = now
+	return true
+}
+
+// setSchedule changes the schedule fields of the job only. Callers hold e.mu.
+func (e *cronEntry) setSchedule(schedule jobSchedule) {
+	e.job.Type = schedule.Type
+	e.job.Cron = schedule.Cron
+	e.job.Interval = schedule.Interval
+	e.job.WaitForSchedule = schedule.WaitForSchedule
+}
+
+func (e *cronEntry) release() {
+	e.mu.Lock()
+	e.running--
+	e.mu.Unlock()
+}
+
+func (e *cronEntry) run() {
+	if !e.acquire(e.clock.Now()) {
+		log.Debug().Str("name", e.job.Name).Msg("cron job run skipped")
+		return
+	}
+	defer e.release()
+	callJobFunction(e.job.Function, e.job.Params...)
+}
+
+func (e *cronEntry) info() JobInfo {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	info := JobInfo{
+		Name:             e.job.Name,
+		Type:             e.job.Type,
+		Cron:             e.job.Cron,
+		Interval:         e.job.Interval,
+		DisableSingleton: e.job.DisableSingleton,
+		Paused:           e.paused,
+		Running:          e.running,
+		RunCount:         e.runCount,
+		SkipCount:        e.skipCount,
+		LastRun:          e.lastRun,
+	}
+	return info
+}
+
+func validateJobFunction(job CronJob) error {
+	f := reflect.ValueOf(job.Function)
+	if f.Kind() != reflect.Func {
+		return fmt.Errorf("function of cron job %s is not a func", job.Name)
+	}
+	if f.Type().NumIn() != len(job.Params) && !f.Type().IsVariadic() {
+		return fmt.Errorf("cron job %s expects %d params, %d given", job.Name, f.Type().NumIn(), len(job.Params))
+	}
+	return nil
+}
+
+func callJobFunction(function interface{}, params ...interface{}) {
+	f := reflect.ValueOf(function)
+	in := make([]reflect.Value, len(params))
+	for i, param := range params {
+		in[i] = reflect.ValueOf(param)
+	}
+	f.Call(in)
+}
+
+func (c *CronService) entry(name string) (*cronEntry, error) {
+	if c.entries == nil {
+		return nil, ErrNotStarted
+	}
+	entry, ok := c.entries[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, name)
+	}
+	return entry, nil
+}
+
+// ListJobs returns all scheduled jobs with their next and last run time.
+func (c *CronService) ListJobs() []JobInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	infos := make([]JobInfo, 0, len(c.entries))
+	for _, job := range c.jobs {
+		if entry, ok := c.entries[strings.ToLower(job.Name)]; ok {
+			info := entry.info()
+			info.NextRun = c.scheduler.nextRun(entry)
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// PauseJob keeps the job scheduled but skips its runs until ResumeJob is called.
+func (c *CronService) PauseJob(name string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, err := c.entry(name)
+	if err != nil {
+		return err
+	}
+	entry.mu.Lock()
+	entry.paused = true
+	entry.mu.Unlock()
+	log.Info().Str("name", entry.job.Name).Msg("cron job paused")
+	return nil
+}
+
+func (c *CronService) ResumeJob(name string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, err := c.entry(name)
+	if err != nil {
+		return err
+	}
+	entry.mu.Lock()
+	entry.paused = false
+	entry.mu.Unlock()
+	log.Info().Str("name", entry.job.Name).Msg("cron job resumed")
+	return nil
+}
+
+// TriggerJob runs the job immediately in the background.
+// Singleton jobs that are already running are not started twice and ErrJobRunning is returned.
+// Paused jobs can still be triggered manually.
+func (c *CronService) TriggerJob(name string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, err := c.entry(name)
+	if err != nil {
+		return err
+	}
+	entry.mu.Lock()
+	if !entry.job.DisableSingleton && entry.running > 0 {
+		entry.skipCount++
+		entry.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrJobRunning, entry.job.Name)
+	}
+	entry.running++
+	entry.runCount++
+	entry.lastRun = c.Clock.Now()
+	entry.mu.Unlock()
+
+	log.Info().Str("name", entry.job.Name).Msg("cron job triggered")
+	go func() {
+		defer entry.release()
+		callJobFunction(entry.job.Function, entry.job.Params...)
+	}()
+	return nil
+}
+
+// RescheduleCron switches the job to a cron schedule (with seconds) at runtime.
+func (c *CronService) RescheduleCron(name string, cronExpression string) error {
+	return c.reschedule(name, func(schedule *jobSchedule) {
+		schedule.Type = CronJobTypeCron
+		schedule.Cron = cronExpression
+	})
+}
+
+// RescheduleInterval switches the job to a fixed interval schedule at runtime.
+// The next run happens after one full interval.
+func (c *CronService) RescheduleInterval(name string, interval time.Duration) error {
+	return c.reschedule(name, func(schedule *jobSchedule) {
+		schedule.Type = CronJobTypeInterval
+		schedule.Interval = interval
+		schedule.WaitForSchedule = true
+	})
+}
+
+// jobSchedule is the part of a CronJob reschedule may change. The other fields are read without entry.mu and must
+// not be written once the job is scheduled.
+type jobSchedule struct {
+	Type            string
+	Cron            string
+	Interval        time.Duration
+	WaitForSchedule bool
+}
+
+func (c *CronService) reschedule(name string, update func(schedule *jobSchedule)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, err := c.entry(name)
+	if err != nil {
+		return err
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	old := jobSchedule{
+		Type:            entry.job.Type,
+		Cron:            entry.job.Cron,
+		Interval:        entry.job.Interval,
+		WaitForSchedule: entry.job.WaitForSchedule,
+	}
+	updated := old
+	update(&updated)
+	entry.setSchedule(updated)
+
+	c.scheduler.unschedule(entry)
+	err = c.scheduler.schedule(entry)
+	if err != nil {
+		// restore the previous schedule so the job keeps running
+		entry.setSchedule(old)
+		if errx := c.scheduler.schedule(entry); errx != nil {
+			log.Error().Err(errx).Str("name", entry.job.Name).Msg("failed to restore cron job schedule")
+		}
+		return err
+	}
+	log.Info().Str("name", entry.job.Name).Str("type", entry.job.Type).Str("cron", entry.job.Cron).
+		Dur("interval", entry.job.Interval).Msg("cron job rescheduled")
+	return nil
+}
@@ -1,9 +1,10 @@
 package cron
 
 import (
-	"github.com/go-co-op/gocron"
+	"fmt"
 	"github.com/rs/zerolog/log"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -34,9 +35,13 @@ type CronJobProvider interface {
 
 type CronService struct {
 	CronJobProvider CronJobProvider
-	cr              *gocron.Scheduler
-	jobs            []CronJob
-	jobDisabled     map[string]bool
+	// Clock is the time source of the scheduler. Defaults to the wall clock; inject a FakeClock in tests.
+	Clock       Clock
+	scheduler   jobScheduler
+	jobs        []CronJob
+	jobDisabled map[string]bool
+	entries     map[string]*cronEntry
+	mu          sync.RWMutex
 }
 
 func (s *CronService) InitJobs() {
@@ -57,46 +62,65 @@ func (s *CronService) InitJobs() {
 	}
 }
 
+// AddJob registers a job directly, bypassing the CronJobProvider. Must be called before Start.
+func (s *CronService) AddJob(job CronJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, job)
+}
+
+// Start schedules the jobs and exits the process when one cannot be scheduled, see StartE.
 func (c *CronService) Start() {
-	c.cr = gocron.NewScheduler(time.UTC)
+	err := c.StartE()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to start cron jobs")
+	}
+}
 
+// StartE schedules the jobs and starts running them. It schedules none and returns an error when a job is invalid
+// or two jobs share a name; names are compared case-insensitively, like the control surface looks them up.
+func (c *CronService) StartE() error {
+	c.mu.Lock()
+	if c.Clock == nil {
+		c.Clock = RealClock()
+	}
+	scheduler := newJobScheduler(c.Clock)
+	entries := make(map[string]*cronEntry, len(c.jobs))
 	for _, job := range c.jobs {
-		if job.Type == CronJobTypeCron {
-			scheduler := c.cr.CronWithSeconds(job.Cron)
-			if !job.DisableSingleton {
-				scheduler = scheduler.SingletonMode()
-			}
-			_, err := scheduler.Do(job.Function, job.Params...)
-			if err != nil {
-				log.Fatal().Err(err).Str("name", job.Name).Msg("failed to start cron job")
-			} else {
-				log.Info().Str("name", job.Name).Msg("cron job started")
-			}
-			continue
-		} else {
-			scheduler := c.cr.Every(job.Interval)
-			if !job.DisableSingleton {
-				scheduler = scheduler.SingletonMode()
-			}
-			if job.WaitForSchedule {
-				scheduler = scheduler.WaitForSchedule()
-			} else {
-				scheduler = scheduler.StartImmediately()
-			}
-			_, err := scheduler.Do(job.Function, job.Params...)
-
-			if err != nil {
-				log.Fatal().Err(err).Str("name", job.Name).Msg("failed to start cron job")
-			} else {
-				log.Info().Str("name", job.Name).Msg("cron job started")
-			}
+		key := strings.ToLower(job.Name)
+		err := validateJobFunction(job)
+		if _, ok := entries[key]; ok {
+			err = fmt.Errorf("duplicate cron job name %s", job.Name)
+		}
+		entry := newCronEntry(job, c.Clock)
+		if err == nil {
+			err = scheduler.schedule(entry)
+		}
+		if err != nil {
+			// drops the jobs scheduled so far
+			scheduler.stop()
+			c.mu.Unlock()
+			return fmt.Errorf("failed to start cron job %s: %w", job.Name, err)
 		}
+		entries[key] = entry
+		log.Info().Str("name", job.Name).Msg("cron job started")
 	}
-	c.cr.StartAsync()
+	c.scheduler = scheduler
+	c.entries = entries
+	c.mu.Unlock()
+
+	scheduler.start()
+	return nil
 }
 
+// Stop stops running the jobs. It does nothing before Start.
 func (c *CronService) Stop() {
-	c.cr.Stop()
+	c.mu.RLock()
+	scheduler := c.scheduler
+	c.mu.RUnlock()
+	if scheduler != nil {
+		scheduler.stop()
+	}
 }
 
 func (c *CronService) Name() string {
@@ -0,0 +1,278 @@
+package cron
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func startService(t *testing.T, clock *FakeClock, jobs ...CronJob) *CronService {
+	t.Helper()
+	s := &CronService{Clock: clock}
+	for _, job := range jobs {
+		s.AddJob(job)
+	}
+	s.Start()
+	t.Cleanup(s.Stop)
+	return s
+}
+
+func jobInfo(t *testing.T, s *CronService, name string) JobInfo {
+	t.Helper()
+	for _, info := range s.ListJobs() {
+		if info.Name == name {
+			return info
+		}
+	}
+	t.Fatalf("job %s not listed", name)
+	return JobInfo{}
+}
+
+func TestIntervalJob(t *testing.T) {
+	clock := NewFakeClock(testStart)
+	var runs []time.Time
+	s := startService(t, clock, CronJob{
+		Name:     "tick",
+		Type:     CronJobTypeInterval,
+		Interval: 10 * time.Second,
+		Function: func() { runs = append(runs, clock.Now()) },
+	})
+
+	clock.Advance(0)
+	if len(runs) != 1 || !runs[0].Equal(testStart) {
+		t.Fatalf("runs after start = %v, want one at %v", runs, testStart)
+	}
+	clock.Advance(25 * time.Second)
+	if len(runs) != 3 {
+		t.Fatalf("runs after 25s = %d, want 3", len(runs))
+	}
+	if want := testStart.Add(20 * time.Second); !runs[2].Equal(want) {
+		t.Errorf("third run at %v, want %v", runs[2], want)
+	}
+	if next := jobInfo(t, s, "tick").NextRun; !next.Equal(testStart.Add(30 * time.Second)) {
+		t.Errorf("next run %v, want %v", next, testStart.Add(30*time.Second))
+	}
+}
+
+func TestIntervalJobWaitForSchedule(t *testing.T) {
+	clock := NewFakeClock(testStart)
+	runs := 0
+	startService(t, clock, CronJob{
+		Name:            "tick",
+		Type:            CronJobTypeInterval,
+		Interval:        10 * time.Second,
+		WaitForSchedule: true,
+		Function:        func() { runs++ },
+	})
+
+	clock.Advance(9 * time.Second)
+	if runs != 0 {
+		t.Fatalf("runs before the first interval = %d, want 0", runs)
+	}
+	clock.Advance(time.Second)
+	if runs != 1 {
+		t.Fatalf("runs after the first interval = %d, want 1", runs)
+	}
+}
+
+func TestCronExpressionJob(t *testing.T) {
+	clock := NewFakeClock(testStart)
+	var runs []time.Time
+	s := startService(t, clock, CronJob{
+		Name:     "quarter",
+		Type:     CronJobTypeCron,
+		Cron:     "*/15 * * * * *",
+		Function: func() { runs = append(runs, clock.Now()) },
+	})
+
+	if next := jobInfo(t, s, "quarter").NextRun; !next.Equal(testStart.Add(15 * time.Second)) {
+		t.Fatalf("next run %v, want %v", next, testStart.Add(15*time.Second))
+	}
+	clock.Advance(time.Minute)
+	if len(runs) != 4 {
+		t.Fatalf("runs in a minute = %d, want 4", len(runs))
+	}
+	for i, run := range runs {
+		if want := testStart.Add(time.Duration(i+1) * 15 * time.Second); !run.Equal(want) {
+			t.Errorf("run %d at %v, want %v", i, run, want)
+		}
+	}
+}
+
+func TestSingletonSkipsOverlap(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		disableSingleton bool
+		runs             int
+		skips            int64
+	}{
+		{name: "singleton", runs: 1, skips: 2},
+		{name: "disabled", disableSingleton: true, runs: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := NewFakeClock(testStart)
+			runs := 0
+			s := startService(t, clock, CronJob{
+				Name:             "slow",
+				Type:             CronJobTypeInterval,
+				Interval:         10 * time.Second,
+				DisableSingleton: tc.disableSingleton,
+				Function: func() {
+					runs++
+					if runs == 1 {
+						// the first run takes 25s, the runs due at 10s and 20s overlap it
+						clock.Advance(25 * time.Second)
+					}
+				},
+			})
+
+			clock.Advance(0)
+			if runs != tc.runs {
+				t.Errorf("runs = %d, want %d", runs, tc.runs)
+			}
+			info := jobInfo(t, s, "slow")
+			if info.SkipCount != tc.skips {
+				t.Errorf("skips = %d, want %d", info.SkipCount, tc.skips)
+			}
+			if info.Running != 0 {
+				t.Errorf("running = %d after the runs returned", info.Running)
+			}
+		})
+	}
+}
+
+func TestPausedJobSkipsRuns(t *testing.T) {
+	clock := NewFakeClock(testStart)
+	runs := 0
+	s := startService(t, clock, CronJob{
+		Name:            "tick",
+		Type:            CronJobTypeInterval,
+		Interval:        10 * time.Second,
+		WaitForSchedule: true,
+		Function:        func() { runs++ },
+	})
+
+	if err := s.PauseJob("tick"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(30 * time.Second)
+	if runs != 0 {
+		t.Fatalf("runs while paused = %d, want 0", runs)
+	}
+	if err := s.ResumeJob("tick"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(10 * time.Second)
+	if runs != 1 {
+		t.Fatalf("runs after resume = %d, want 1", runs)
+	}
+}
+
+func TestReschedule(t *testing.T) {
+	clock := NewFakeClock(testStart)
+	runs := 0
+	s := startService(t, clock, CronJob{
+		Name:            "tick",
+		Type:            CronJobTypeInterval,
+		Interval:        10 * time.Second,
+		WaitForSchedule: true,
+		Function:        func() { runs++ },
+	})
+
+	if err := s.RescheduleInterval("tick", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(59 * time.Second)
+	if runs != 0 {
+		t.Fatalf("runs before the new interval = %d, want 0", runs)
+	}
+	clock.Advance(time.Second)
+	if runs != 1 {
+		t.Fatalf("runs after the new interval = %d, want 1", runs)
+	}
+
+	if err := s.RescheduleCron("tick", "not a cron"); err == nil {
+		t.Fatal("invalid cron expression accepted")
+	}
+	info := jobInfo(t, s, "tick")
+	if info.Type != CronJobTypeInterval || info.Interval != time.Minute {
+		t.Fatalf("schedule after a failed reschedule = %s %s, want interval 1m", info.Type, info.Interval)
+	}
+	if want := testStart.Add(2 * time.Minute); !info.NextRun.Equal(want) {
+		t.Fatalf("next run after a failed reschedule = %v, want %v", info.NextRun, want)
+	}
+
+	if err := s.RescheduleCron("tick", "30 * * * * *"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(30 * time.Second)
+	if runs != 2 {
+		t.Fatalf("runs after the cron schedule = %d, want 2", runs)
+	}
+	if info := jobInfo(t, s, "tick"); info.Type != CronJobTypeCron || info.Cron != "30 * * * * *" {
+		t.Errorf("schedule = %s %q, want cron", info.Type, info.Cron)
+	}
+}
+
+func TestControlErrors(t *testing.T) {
+	s := &CronService{Clock: NewFakeClock(testStart)}
+	if err := s.PauseJob("missing"); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("pause before start: %v, want ErrNotStarted", err)
+	}
+	s = startService(t, NewFakeClock(testStart))
+	if err := s.TriggerJob("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("trigger unknown job: %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestStartRejectsDuplicateNames(t *testing.T) {
+	clock := NewFakeClock(testStart)
+	runs := 0
+	s := &CronService{Clock: clock}
+	s.AddJob(CronJob{Name: "tick", Type: CronJobTypeInterval, Interval: time.Second, Function: func() { runs++ }})
+	s.AddJob(CronJob{Name: "Tick", Type: CronJobTypeInterval, Interval: time.Second, Function: func() { runs++ }})
+	if err := s.StartE(); err == nil {
+		t.Fatal("started two jobs named tick")
+	}
+	clock.Advance(time.Minute)
+	if runs != 0 {
+		t.Errorf("runs = %d after a failed start, want 0", runs)
+	}
+	if err := s.TriggerJob("tick"); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("trigger after a failed start: %v, want ErrNotStarted", err)
+	}
+	s.Stop()
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	s := &CronService{}
+	s.Stop()
+}
+
+type sleepClock struct {
+	slept time.Duration
+}
+
+func (c *sleepClock) Now() time.Time {
+	return testStart
+}
+
+func (c *sleepClock) Sleep(d time.Duration) {
+	c.slept += d
+}
+
+func TestCustomClockDrivesGocron(t *testing.T) {
+	clock := &sleepClock{}
+	if _, ok := newJobScheduler(clock).(*gocronScheduler); !ok {
+		t.Fatal("a clock that does not schedule jobs itself is not handed to gocron")
+	}
+	if _, ok := newJobScheduler(NewFakeClock(testStart)).(*fakeScheduler); !ok {
+		t.Fatal("FakeClock does not schedule its jobs itself")
+	}
+	timeWrapper{clock: clock}.Sleep(time.Hour)
+	if clock.slept != time.Hour {
+		t.Errorf("slept %s on the clock, want 1h", clock.slept)
+	}
+}
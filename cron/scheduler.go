@@ -0,0 +1,130 @@
+package cron
+
+import (
+	"fmt"
+	"github.com/go-co-op/gocron"
+	"sync"
+	"time"
+)
+
+// jobScheduler drives the runs of cron entries. The default implementation is backed by gocron on wall-clock
+// time, a FakeClock provides a deterministic one for tests.
+type jobScheduler interface {
+	schedule(entry *cronEntry) error
+	unschedule(entry *cronEntry)
+	nextRun(entry *cronEntry) time.Time
+	start()
+	stop()
+}
+
+// schedulingClock is a Clock that runs the jobs itself instead of gocron, like FakeClock.
+type schedulingClock interface {
+	Clock
+	newScheduler() jobScheduler
+}
+
+// Sleeper is implemented by clocks that also decide how long the gocron scheduler sleeps. Other clocks sleep on the
+// wall clock.
+type Sleeper interface {
+	Sleep(d time.Duration)
+}
+
+func newJobScheduler(clock Clock) jobScheduler {
+	if sc, ok := clock.(schedulingClock); ok {
+		return sc.newScheduler()
+	}
+	return newGocronScheduler(clock)
+}
+
+type gocronScheduler struct {
+	cr   *gocron.Scheduler
+	jobs map[*cronEntry]*gocron.Job
+	mu   sync.Mutex
+}
+
+func newGocronScheduler(clock Clock) *gocronScheduler {
+	cr := gocron.NewScheduler(time.UTC)
+	if _, ok := clock.(realClock); !ok && clock != nil {
+		cr.CustomTime(timeWrapper{clock: clock})
+	}
+	return &gocronScheduler{
+		cr:   cr,
+		jobs: make(map[*cronEntry]*gocron.Job),
+	}
+}
+
+func (g *gocronScheduler) schedule(entry *cronEntry) (err error) {
+	job := entry.job
+	var scheduler *gocron.Scheduler
+	// overlapping runs are skipped by cronEntry.run, not by gocron's SingletonMode, which would queue them
+	if job.Type == CronJobTypeCron {
+		scheduler = g.cr.CronWithSeconds(job.Cron)
+	} else {
+		if job.Interval <= 0 {
+			return fmt.Errorf("invalid interval %s for cron job %s", job.Interval, job.Name)
+		}
+		scheduler = g.cr.Every(job.Interval)
+		if job.WaitForSchedule {
+			scheduler = scheduler.WaitForSchedule()
+		} else {
+			scheduler = scheduler.StartImmediately()
+		}
+	}
+	gj, err := scheduler.Tag(job.Name).Do(entry.run)
+	if err != nil {
+		return
+	}
+	g.mu.Lock()
+	g.jobs[entry] = gj
+	g.mu.Unlock()
+	return
+}
+
+func (g *gocronScheduler) unschedule(entry *cronEntry) {
+	g.mu.Lock()
+	gj, ok := g.jobs[entry]
+	delete(g.jobs, entry)
+	g.mu.Unlock()
+	if ok {
+		g.cr.RemoveByReference(gj)
+	}
+}
+
+func (g *gocronScheduler) nextRun(entry *cronEntry) time.Time {
+	g.mu.Lock()
+	gj, ok := g.jobs[entry]
+	g.mu.Unlock()
+	if !ok {
+		return time.Time{}
+	}
+	return gj.NextRun()
+}
+
+func (g *gocronScheduler) start() {
+	g.cr.StartAsync()
+}
+
+func (g *gocronScheduler) stop() {
+	g.cr.Stop()
+}
+
+// timeWrapper adapts a Clock to gocron.TimeWrapper
+type timeWrapper struct {
+	clock Clock
+}
+
+func (t timeWrapper) Now(location *time.Location) time.Time {
+	return t.clock.Now().In(location)
+}
+
+func (t timeWrapper) Unix(sec int64, nsec int64) time.Time {
+	return time.Unix(sec, nsec)
+}
+
+func (t timeWrapper) Sleep(d time.Duration) {
+	if sleeper, ok := t.clock.(Sleeper); ok {
+		sleeper.Sleep(d)
+		return
+	}
+	time.Sleep(d)
+}
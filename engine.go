@@ -17,6 +17,8 @@ type BasicEngine struct {
 	DumpConfigOnStart bool
 	LogLevel          string
 	PostBootLatency   time.Duration
+	// Clock drives cron jobs. Defaults to the wall clock.
+	Clock cron.Clock
 
 	bootService      *boot.BootService
 	cronService      *cron.CronService
@@ -67,6 +69,7 @@ func (b *BasicEngine) setup() {
 	}
 
 	if b.cronService != nil {
+		b.cronService.Clock = b.Clock
 		b.cronService.InitJobs()
 	}
 }
@@ -91,7 +94,7 @@ func (b *BasicEngine) Start() {
 	b.componentService.AddComponent(b.cronService)
 
 	// prevent sudden stop. Do your clean up here
-	var gracefulStop = make(chan os.Signal)
+	var gracefulStop = make(chan os.Signal, 1)
 
 	signal.Notify(gracefulStop, syscall.SIGTERM)
 	signal.Notify(gracefulStop, syscall.SIGINT)
@@ -1,15 +1,16 @@
 package latigo
 
 import (
-	"github.com/go-co-op/gocron"
 	"github.com/latifrons/latigo/boot"
 	"github.com/latifrons/latigo/cron"
+	"github.com/latifrons/latigo/logging"
 	"github.com/latifrons/latigo/program"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 )
 
 type BootType string
@@ -18,25 +19,126 @@ const BootTypeOnce BootType = "once"
 const BootTypeCron BootType = "cron"
 const BootTypeComponent BootType = "component"
 
+// ExitCodeConfig is the exit code for unusable configuration, EX_CONFIG of sysexits.h.
+const ExitCodeConfig = 78
+
 type BootSequence struct {
 	Type BootType
 	Job  interface{}
 }
 
 type EngineV2 struct {
-	Name                 string
-	EnvPrefix            string
-	DumpConfigOnStart    bool
-	LogLevel             string
-	Jobs                 []BootSequence
+	Name    string
+	Version string
+	// Folders are the default folders of Command, set by LoadConfigs. Start locks the data folder, see
+	// program.AcquirePIDLock.
+	Folders           program.FolderConfig
+	EnvPrefix         string
+	DumpConfigOnStart bool
+	LogLevel          string
+	Jobs              []BootSequence
+	// Config is passed to components implementing program.Configurable. Defaults to program.GlobalConfig.
+	Config *program.Config
+	// Clock drives cron jobs. Defaults to the wall clock.
+	Clock                cron.Clock
 	registeredCrons      []cron.CronJob
 	registeredComponents []program.Component
-	cr                   *gocron.Scheduler
+	cronService          *cron.CronService
+	pidLock              *program.PIDLock
+	reloader             *program.ConfigReloader
+}
+
+// LoadConfigs loads the global config with the engine EnvPrefix, see program.LoadConfigs, and sets Config to it.
+// The config is dumped when DumpConfigOnStart is set. On failure it logs the problem and exits with ExitCodeConfig.
+func (b *EngineV2) LoadConfigs(folderConfig program.FolderConfig) program.FolderConfig {
+	folderConfigActual, err := program.LoadConfigsE(folderConfig, b.EnvPrefix)
+	if err != nil {
+		log.Error().Str("name", b.Name).Msg(err.Error())
+		os.Exit(ExitCodeConfig)
+	}
+	b.Config = program.GlobalConfig()
+	b.Folders = folderConfigActual
+	if b.DumpConfigOnStart {
+		b.Config.Dump()
+	}
+	return folderConfigActual
+}
+
+// LoadConfig is LoadConfigs into a config of the engine's own, leaving the global viper alone, so several engines
+// can run in one process.
+func (b *EngineV2) LoadConfig(folderConfig program.FolderConfig) *program.Config {
+	cfg, err := program.LoadConfig(folderConfig, b.EnvPrefix, program.ProfileFromEnv(b.EnvPrefix))
+	if err != nil {
+		log.Error().Str("name", b.Name).Msg(err.Error())
+		os.Exit(ExitCodeConfig)
+	}
+	b.Config = cfg
+	b.Folders = cfg.Folders()
+	if b.DumpConfigOnStart {
+		cfg.Dump()
+	}
+	return cfg
+}
+
+func (b *EngineV2) config() *program.Config {
+	if b.Config == nil {
+		return program.GlobalConfig()
+	}
+	return b.Config
+}
+
+// EnableConfigReload reloads Config while the engine runs, on SIGHUP and with program.WithWatch when the files
+// change. A reload with an invalid log_level is rejected, a valid one is applied. Components may subscribe to the
+// returned reloader for their own keys.
+func (b *EngineV2) EnableConfigReload(opts ...program.ReloadOption) *program.ConfigReloader {
+	opts = append(opts, program.WithReloadCheck(func(candidate *viper.Viper) error {
+		_, err := parseLogLevel(candidate.GetString(KeyLogLevel))
+		return err
+	}))
+	b.reloader = b.config().NewReloader(opts...)
+	b.reloader.Subscribe([]string{KeyLogLevel}, func(changed []string) {
+		err := b.applyLogLevel()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to apply the reloaded log level")
+		}
+	})
+	return b.reloader
+}
+
+// applyLogLevel sets up the default logger with the log_level of Config, if any.
+func (b *EngineV2) applyLogLevel() error {
+	cfg := b.config()
+	b.LogLevel = cfg.GetString(KeyLogLevel)
+	if b.LogLevel == "" {
+		return nil
+	}
+	level, err := parseLogLevel(b.LogLevel)
+	if err != nil {
+		return program.ConfigError{Key: KeyLogLevel, Source: cfg.Source(KeyLogLevel), Message: err.Error()}
+	}
+	logging.SetupDefaultLogger(level)
+	return nil
+}
+
+func parseLogLevel(s string) (zerolog.Level, error) {
+	if s == "" {
+		return zerolog.NoLevel, nil
+	}
+	return zerolog.ParseLevel(s)
+}
+
+// AddComponent appends a component to the boot sequence.
+func (b *EngineV2) AddComponent(component program.Component) {
+	b.Jobs = append(b.Jobs, BootSequence{Type: BootTypeComponent, Job: component})
 }
 
 func (b *EngineV2) setup() {
 	b.registeredCrons = []cron.CronJob{}
 	b.registeredComponents = []program.Component{}
+	// exists before the components start, so they can hold on to CronJobs
+	b.cronService = &cron.CronService{
+		Clock: b.Clock,
+	}
 }
 
 func (b *EngineV2) Start() {
@@ -44,6 +146,15 @@ func (b *EngineV2) Start() {
 	b.setup()
 
 	var err error
+	if b.Folders.Data != "" {
+		b.pidLock, err = program.AcquirePIDLock(b.Folders.Data)
+		if err != nil {
+			log.Fatal().Err(err).Str("name", b.Name).Msg("another instance is using the data folder")
+		}
+	}
+	if b.reloader != nil {
+		b.reloader.Start()
+	}
 
 	for _, job := range b.Jobs {
 
@@ -62,55 +173,39 @@ func (b *EngineV2) Start() {
 			}
 		case BootTypeComponent:
 			component := job.Job.(program.Component)
-			b.registeredComponents = append(b.registeredComponents, component)
+			if configurable, ok := component.(program.Configurable); ok {
+				err = configurable.Configure(b.config())
+				if err != nil {
+					log.Fatal().Err(err).Str("name", component.Name()).Msg("failed to configure component")
+				}
+			}
 			log.Info().Str("name", component.Name()).Msg("starting component")
-			component.Start()
+			err = program.StartComponent(component)
+			if err != nil {
+				log.Error().Err(err).Str("name", component.Name()).Msg("failed to start component")
+				b.shutdown(false)
+				os.Exit(1)
+			}
+			b.registeredComponents = append(b.registeredComponents, component)
 			log.Info().Str("name", component.Name()).Msg("started component")
 		case BootTypeCron:
 			cronJob := job.Job.(cron.CronJob)
 			b.registeredCrons = append(b.registeredCrons, cronJob)
+			b.cronService.AddJob(cronJob)
 			log.Info().Str("name", cronJob.Name).Msg("registered cron job")
 			// run later
 		}
 	}
 
-	b.cr = gocron.NewScheduler(time.UTC)
-	for _, job := range b.registeredCrons {
-		if job.Type == cron.CronJobTypeCron {
-			scheduler := b.cr.CronWithSeconds(job.Cron)
-			if !job.DisableSingleton {
-				scheduler = scheduler.SingletonMode()
-			}
-			_, err := scheduler.Do(job.Function, job.Params...)
-			if err != nil {
-				log.Fatal().Err(err).Str("name", job.Name).Msg("failed to start cron job")
-			} else {
-				log.Info().Str("name", job.Name).Msg("cron job started")
-			}
-			continue
-		} else {
-			scheduler := b.cr.Every(job.Interval)
-			if !job.DisableSingleton {
-				scheduler = scheduler.SingletonMode()
-			}
-			if job.WaitForSchedule {
-				scheduler = scheduler.WaitForSchedule()
-			} else {
-				scheduler = scheduler.StartImmediately()
-			}
-			_, err := scheduler.Do(job.Function, job.Params...)
-
-			if err != nil {
-				log.Fatal().Err(err).Str("name", job.Name).Msg("failed to start cron job")
-			} else {
-				log.Info().Str("name", job.Name).Msg("cron job started")
-			}
-		}
+	err = b.cronService.StartE()
+	if err != nil {
+		log.Error().Err(err).Str("name", b.Name).Msg("failed to start cron jobs")
+		b.shutdown(false)
+		os.Exit(1)
 	}
-	b.cr.StartAsync()
 
 	// prevent sudden stop. Do your clean up here
-	var gracefulStop = make(chan os.Signal)
+	var gracefulStop = make(chan os.Signal, 1)
 
 	signal.Notify(gracefulStop, syscall.SIGTERM)
 	signal.Notify(gracefulStop, syscall.SIGINT)
@@ -119,18 +214,49 @@ func (b *EngineV2) Start() {
 		sig := <-gracefulStop
 		log.Info().Str("name", b.Name).Str("sig", sig.String()).Msg("caught sig")
 		log.Info().Str("name", b.Name).Msg("Exiting... Please do no kill me")
+		b.shutdown(true)
+		os.Exit(0)
+	}()
+}
+
+// shutdown stops the reloader, the cron jobs when started, the started components and releases the pid lock.
+func (b *EngineV2) shutdown(cronStarted bool) {
+	if b.reloader != nil {
+		b.reloader.Stop()
+	}
+	if cronStarted {
 		// stop crons
 		log.Info().Msg("stopping cron jobs")
-		b.cr.Stop()
+		b.cronService.Stop()
 		log.Info().Msg("stopped cron jobs")
-		// stop components
-		for _, component := range b.registeredComponents {
-			log.Info().Str("name", component.Name()).Msg("stopping component")
-			component.Stop()
-			log.Info().Str("name", component.Name()).Msg("stopped component")
+	}
+	// stop components
+	for _, component := range b.registeredComponents {
+		log.Info().Str("name", component.Name()).Msg("stopping component")
+		component.Stop()
+		log.Info().Str("name", component.Name()).Msg("stopped component")
+	}
+	if b.pidLock != nil {
+		err := b.pidLock.Release()
+		if err != nil {
+			log.Error().Err(err).Str("path", b.pidLock.Path()).Msg("failed to release pid lock")
 		}
-		os.Exit(0)
-	}()
+	}
+}
+
+// CronJobs exposes runtime control of the registered cron jobs. It is nil before Start. Components may call it in
+// their Start; the controller returns cron.ErrNotStarted until every component has started and the jobs are
+// scheduled.
+func (b *EngineV2) CronJobs() cron.JobController {
+	if b.cronService == nil {
+		return nil
+	}
+	return b.cronService
+}
+
+// Health reports the health of the started components implementing program.HealthChecker.
+func (b *EngineV2) Health() map[string]error {
+	return program.CheckHealth(b.registeredComponents)
 }
 
 func NewDefaultEngineV2() EngineV2 {
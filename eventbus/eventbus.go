@@ -0,0 +1,91 @@
+// Package eventbus lets modules publish and subscribe to events without depending on a transport.
+// NewRabbitBus carries events over a RabbitMQ topic exchange, NewMemoryBus delivers them in process,
+// for tests and for deploying several modules as one binary.
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/latifrons/latigo/mq/codec"
+	"time"
+)
+
+var ErrClosed = errors.New("event bus closed")
+
+// ErrNotStarted is returned by RabbitBus.Publish before Start.
+var ErrNotStarted = errors.New("bus not started")
+
+// Event is a message on a topic. Topics are dot separated words, e.g. "order.created".
+type Event struct {
+	Topic       string
+	ID          string
+	Headers     map[string]string
+	ContentType string
+	Body        []byte
+	Timestamp   time.Time
+}
+
+// NewEvent encodes v as the body of an event on topic.
+func NewEvent(topic string, c codec.Codec, v interface{}) (e Event, err error) {
+	e.Body, err = c.Marshal(v)
+	if err != nil {
+		return
+	}
+	e.Topic = topic
+	e.ContentType = c.ContentType()
+	return
+}
+
+// Decode unmarshals the body into v with the codec registered for the event content type.
+func (e Event) Decode(v interface{}) error {
+	c, ok := codec.ByContentType(e.ContentType)
+	if !ok {
+		return fmt.Errorf("no codec for content type %s", e.ContentType)
+	}
+	return c.Unmarshal(e.Body, v)
+}
+
+// withDefaults fills a missing ID and Timestamp.
+func (e Event) withDefaults() Event {
+	if e.ID == "" {
+		e.ID = uuid.NewString()
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	return e
+}
+
+// Handler handles one event. A returned error is logged. On RabbitMQ the event is then retried when the subscriber
+// has a group and the bus a retry policy, see WithRetryPolicy; otherwise it is rejected and dropped.
+type Handler func(ctx context.Context, e Event) error
+
+type Subscription interface {
+	Unsubscribe() error
+}
+
+// EventBus publishes events and delivers them to subscribers.
+//
+// Subscribe routes events whose topic matches pattern to h. In a pattern "*" matches one word and "#" zero or
+// more words. Subscribers with the same non-empty group share the events: each event goes to one of them.
+// Subscribers without a group each receive every matching event.
+type EventBus interface {
+	Publish(ctx context.Context, e Event) error
+	Subscribe(pattern string, group string, h Handler) (Subscription, error)
+	Close() error
+}
+
+// clone copies headers and body so subscribers cannot affect each other.
+func (e Event) clone() Event {
+	if e.Headers != nil {
+		headers := make(map[string]string, len(e.Headers))
+		for k, v := range e.Headers {
+			headers[k] = v
+		}
+		e.Headers = headers
+	}
+	e.Body = append([]byte(nil), e.Body...)
+	return e
+}
@@ -0,0 +1,278 @@
+package eventbus
+
+import (
+	"context"
+	"github.com/latifrons/latigo/mq/topology"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"sync"
+)
+
+type MemoryOption func(*MemoryBus)
+
+// WithBuffer sets how many events a group holds before Publish blocks. Defaults to 64.
+func WithBuffer(size int) MemoryOption {
+	return func(b *MemoryBus) {
+		b.buffer = size
+	}
+}
+
+// MemoryBus delivers events in process over channels. Each group, and each subscriber without a group, has its own
+// buffered channel; Publish blocks while a matching one is full. A handler publishing into its own full group does
+// not block, the event is queued behind the buffer instead.
+type MemoryBus struct {
+	buffer int
+
+	mu         sync.RWMutex
+	groups     map[string]*memoryGroup
+	private    int
+	closed     bool
+	done       chan struct{}
+	drain      chan struct{}
+	publishing sync.WaitGroup
+	wg         sync.WaitGroup
+}
+
+var _ EventBus = (*MemoryBus)(nil)
+
+// memoryGroup mirrors a queue: it receives events matching any pattern of its members.
+type memoryGroup struct {
+	key     string
+	name    string
+	members map[*memorySubscription]string
+	events  chan Event
+	// done is closed when the last member leaves
+	done chan struct{}
+
+	overflowMu sync.Mutex
+	overflow   []Event
+}
+
+type memorySubscription struct {
+	bus   *MemoryBus
+	group *memoryGroup
+	quit  chan struct{}
+}
+
+// handlerGroupKey marks the context of a handler with its group.
+type handlerGroupKey struct{}
+
+func NewMemoryBus(opts ...MemoryOption) *MemoryBus {
+	b := &MemoryBus{
+		buffer: 64,
+		groups: make(map[string]*memoryGroup),
+		done:   make(chan struct{}),
+		drain:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+func (b *MemoryBus) Publish(ctx context.Context, e Event) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	e = e.withDefaults()
+
+	// send without the lock, so a blocked Publish holds up neither Subscribe nor the handlers
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return ErrClosed
+	}
+	var targets []*memoryGroup
+	for _, g := range b.groups {
+		if g.matches(e.Topic) {
+			targets = append(targets, g)
+		}
+	}
+	b.publishing.Add(1)
+	b.mu.RUnlock()
+	defer b.publishing.Done()
+
+	for _, g := range targets {
+		err := g.send(ctx, e.clone(), b.done)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send waits for room in the buffer unless called from a handler of the group. An event for a group whose last
+// member left meanwhile is dropped.
+func (g *memoryGroup) send(ctx context.Context, e Event, closed chan struct{}) error {
+	select {
+	case g.events <- e:
+		return nil
+	default:
+	}
+	if ctx.Value(handlerGroupKey{}) == g {
+		g.overflowMu.Lock()
+		g.overflow = append(g.overflow, e)
+		g.overflowMu.Unlock()
+		return nil
+	}
+	select {
+	case g.events <- e:
+		return nil
+	case <-g.done:
+		return nil
+	case <-closed:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *memoryGroup) popOverflow() (Event, bool) {
+	g.overflowMu.Lock()
+	defer g.overflowMu.Unlock()
+	if len(g.overflow) == 0 {
+		return Event{}, false
+	}
+	e := g.overflow[0]
+	g.overflow = g.overflow[1:]
+	return e, true
+}
+
+func (b *MemoryBus) Subscribe(pattern string, group string, h Handler) (Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	key := "group:" + group
+	if group == "" {
+		b.private++
+		key = "private:" + strconv.Itoa(b.private)
+	}
+	g, ok := b.groups[key]
+	if !ok {
+		g = &memoryGroup{
+			key:     key,
+			name:    group,
+			members: make(map[*memorySubscription]string),
+			events:  make(chan Event, b.buffer),
+			done:    make(chan struct{}),
+		}
+		b.groups[key] = g
+	}
+	s := &memorySubscription{
+		bus:   b,
+		group: g,
+		quit:  make(chan struct{}),
+	}
+	g.members[s] = pattern
+
+	b.wg.Add(1)
+	go s.run(h)
+	return s, nil
+}
+
+// Close stops accepting events and waits until subscribers have handled the buffered ones. A Publish blocked on a
+// full group returns ErrClosed.
+func (b *MemoryBus) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	b.mu.Unlock()
+	b.publishing.Wait()
+	close(b.drain)
+	b.wg.Wait()
+	return nil
+}
+
+func (g *memoryGroup) matches(topic string) bool {
+	for _, pattern := range g.members {
+		if topology.MatchTopic(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unsubscribe stops the subscriber. The group goes with its last member; its pending events are dropped and logged.
+func (s *memorySubscription) Unsubscribe() error {
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g := s.group
+	if _, ok := g.members[s]; !ok {
+		return nil
+	}
+	delete(g.members, s)
+	close(s.quit)
+	if len(g.members) > 0 || b.closed {
+		return nil
+	}
+	delete(b.groups, g.key)
+	close(g.done)
+
+	dropped := 0
+	for {
+		select {
+		case <-g.events:
+			dropped++
+			continue
+		default:
+		}
+		break
+	}
+	g.overflowMu.Lock()
+	dropped += len(g.overflow)
+	g.overflow = nil
+	g.overflowMu.Unlock()
+	if dropped > 0 {
+		log.Warn().Int("count", dropped).Str("group", g.name).Msg("dropped pending events of the last unsubscribed member")
+	}
+	return nil
+}
+
+func (s *memorySubscription) run(h Handler) {
+	defer s.bus.wg.Done()
+	ctx := context.WithValue(context.Background(), handlerGroupKey{}, s.group)
+	for {
+		e, ok := s.next()
+		if !ok {
+			return
+		}
+		err := h(ctx, e)
+		if err != nil {
+			log.Error().Err(err).Str("topic", e.Topic).Str("id", e.ID).Str("group", s.group.name).Msg("event handler failed")
+		}
+	}
+}
+
+// next returns the next event: buffered ones first, then those queued by the handlers of the group. After Close
+// it returns what is left and then stops.
+func (s *memorySubscription) next() (Event, bool) {
+	g := s.group
+	select {
+	case e := <-g.events:
+		return e, true
+	default:
+	}
+	if e, ok := g.popOverflow(); ok {
+		return e, true
+	}
+	select {
+	case e := <-g.events:
+		return e, true
+	case <-s.quit:
+		return Event{}, false
+	case <-s.bus.drain:
+		select {
+		case e := <-g.events:
+			return e, true
+		default:
+		}
+		return g.popOverflow()
+	}
+}
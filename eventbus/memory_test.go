@@ -0,0 +1,140 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryBusDelivers(t *testing.T) {
+	b := NewMemoryBus()
+	var mu sync.Mutex
+	received := map[string]int{}
+	record := func(name string) Handler {
+		return func(ctx context.Context, e Event) error {
+			mu.Lock()
+			received[name]++
+			mu.Unlock()
+			return nil
+		}
+	}
+	subscribe(t, b, "order.*", "", record("private1"))
+	subscribe(t, b, "#", "", record("private2"))
+	subscribe(t, b, "order.created", "billing", record("billing"))
+	subscribe(t, b, "order.created", "billing", record("billing"))
+	subscribe(t, b, "payment.*", "", record("payment"))
+
+	for i := 0; i < 3; i++ {
+		if err := b.Publish(context.Background(), Event{Topic: "order.created"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Close waits for the buffered events
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"private1": 3, "private2": 3, "billing": 3}
+	for name, n := range want {
+		if received[name] != n {
+			t.Errorf("%s received %d events, want %d", name, received[name], n)
+		}
+	}
+	if received["payment"] != 0 {
+		t.Errorf("payment received %d events, want 0", received["payment"])
+	}
+
+	if err := b.Publish(context.Background(), Event{Topic: "order.created"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("publish after Close: %v, want ErrClosed", err)
+	}
+	if _, err := b.Subscribe("order.*", "", record("late")); !errors.Is(err, ErrClosed) {
+		t.Errorf("subscribe after Close: %v, want ErrClosed", err)
+	}
+}
+
+func TestMemoryBusClonesEvents(t *testing.T) {
+	b := NewMemoryBus()
+	bodies := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		subscribe(t, b, "order.created", "", func(ctx context.Context, e Event) error {
+			bodies <- string(e.Body)
+			e.Body[0] = 'x'
+			return nil
+		})
+	}
+	if err := b.Publish(context.Background(), Event{Topic: "order.created", Body: []byte("abc")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if body := <-bodies; body != "abc" {
+			t.Errorf("subscriber received %q, want abc", body)
+		}
+	}
+}
+
+func TestMemoryBusHandlerPublishesToFullGroup(t *testing.T) {
+	b := NewMemoryBus(WithBuffer(1))
+	done := make(chan struct{})
+	count := 0
+	subscribe(t, b, "tick", "clock", func(ctx context.Context, e Event) error {
+		count++
+		if count < 5 {
+			// twice per event, so the buffer of one overflows
+			for i := 0; i < 2; i++ {
+				if err := b.Publish(ctx, Event{Topic: "tick"}); err != nil {
+					return err
+				}
+			}
+		} else if count == 5 {
+			close(done)
+		}
+		return nil
+	})
+	if err := b.Publish(context.Background(), Event{Topic: "tick"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler blocked publishing into its own full group")
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryBusUnsubscribe(t *testing.T) {
+	b := NewMemoryBus()
+	defer b.Close()
+	received := make(chan Event, 10)
+	s := subscribe(t, b, "order.*", "", func(ctx context.Context, e Event) error {
+		received <- e
+		return nil
+	})
+	if err := b.Publish(context.Background(), Event{Topic: "order.created"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not delivered")
+	}
+	if err := s.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unsubscribe(); err != nil {
+		t.Errorf("second Unsubscribe: %v", err)
+	}
+	if err := b.Publish(context.Background(), Event{Topic: "order.created"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-received:
+		t.Errorf("received %+v after Unsubscribe", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,265 @@
+package eventbus
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/latifrons/latigo/mq/consumer"
+	"github.com/latifrons/latigo/mq/publisher"
+	"github.com/latifrons/latigo/mq/topology"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+	"sync"
+)
+
+type RabbitOption func(*RabbitBus)
+
+// WithDurable makes the exchange and the group queues durable. Defaults to true.
+func WithDurable(durable bool) RabbitOption {
+	return func(b *RabbitBus) {
+		b.durable = durable
+	}
+}
+
+func WithPublisherOptions(opts ...publisher.PublisherOption) RabbitOption {
+	return func(b *RabbitBus) {
+		b.publisherOpts = append(b.publisherOpts, opts...)
+	}
+}
+
+// WithConsumerOptions applies to the consumer of every subscription.
+func WithConsumerOptions(opts ...consumer.ConsumerOption) RabbitOption {
+	return func(b *RabbitBus) {
+		b.consumerOpts = append(b.consumerOpts, opts...)
+	}
+}
+
+// WithRetryPolicy retries failed events of group subscriptions, see consumer.RetryPolicy.
+// Without it, and always for subscribers without a group, a failed event is rejected and dropped: the queues of the
+// bus have no dead letter exchange and requeueing could redeliver a failing event forever.
+func WithRetryPolicy(policy consumer.RetryPolicy) RabbitOption {
+	return func(b *RabbitBus) {
+		b.retry = &policy
+	}
+}
+
+// RabbitBus carries events over a RabbitMQ topic exchange, with the topic as routing key. A group is a queue
+// named after it; subscribers without a group get an exclusive, auto-deleted queue.
+type RabbitBus struct {
+	URL      string
+	Exchange string
+
+	durable       bool
+	publisherOpts []publisher.PublisherOption
+	consumerOpts  []consumer.ConsumerOption
+	retry         *consumer.RetryPolicy
+	publisher     busPublisher
+	// newConsumer creates the consumer of a subscription; with retry set it retries failures per the policy
+	newConsumer func(queue string, t topology.Topology, handle consumer.HandleFunc, retry consumer.ErrorHandleFunc) (consumer.MessageConsumer, error)
+
+	mu      sync.Mutex
+	subs    map[*rabbitSubscription]struct{}
+	started bool
+	closed  bool
+}
+
+// busPublisher is the publisher of a RabbitBus, a *publisher.ReliableRabbitPublisher outside tests.
+type busPublisher interface {
+	Publish(ctx context.Context, exchange, key string, msg amqp091.Publishing) error
+	Start() error
+	Stop()
+}
+
+var _ EventBus = (*RabbitBus)(nil)
+
+type rabbitSubscription struct {
+	bus      *RabbitBus
+	consumer consumer.MessageConsumer
+}
+
+func NewRabbitBus(url string, exchange string, opts ...RabbitOption) *RabbitBus {
+	b := &RabbitBus{
+		URL:      url,
+		Exchange: exchange,
+		durable:  true,
+		subs:     make(map[*rabbitSubscription]struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.publisher = publisher.NewReliableRabbitPublisher(url,
+		append([]publisher.PublisherOption{publisher.WithTopology(b.exchangeTopology())}, b.publisherOpts...)...)
+	b.newConsumer = b.rabbitConsumer
+	return b
+}
+
+func (b *RabbitBus) exchangeTopology() topology.Topology {
+	return topology.Topology{
+		Exchanges: []topology.Exchange{{
+			Name:    b.Exchange,
+			Kind:    amqp091.ExchangeTopic,
+			Durable: b.durable,
+		}},
+	}
+}
+
+// Start connects the publisher. Subscribe connects each subscription on its own.
+func (b *RabbitBus) Start() error {
+	err := b.publisher.Start()
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.started = true
+	b.mu.Unlock()
+	return nil
+}
+
+// Publish returns ErrNotStarted before Start and ErrClosed after Close.
+func (b *RabbitBus) Publish(ctx context.Context, e Event) error {
+	b.mu.Lock()
+	started, closed := b.started, b.closed
+	b.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	if !started {
+		return ErrNotStarted
+	}
+	e = e.withDefaults()
+	return b.publisher.Publish(ctx, b.Exchange, e.Topic, toPublishing(e))
+}
+
+func (b *RabbitBus) Subscribe(pattern string, group string, h Handler) (Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	queue := topology.Queue{Name: group, Durable: b.durable}
+	if group == "" {
+		queue = topology.Queue{Name: b.Exchange + "." + uuid.NewString(), AutoDelete: true, Exclusive: true}
+	}
+	t := b.exchangeTopology()
+	t.Queues = append(t.Queues, queue)
+	t.Bindings = append(t.Bindings, topology.Binding{Queue: queue.Name, Exchange: b.Exchange, RoutingKey: pattern})
+
+	var retry consumer.ErrorHandleFunc
+	if b.retry != nil && group != "" {
+		retry = func(ctx context.Context, msg amqp091.Delivery) error {
+			return h(ctx, fromDelivery(msg))
+		}
+	}
+	c, err := b.newConsumer(queue.Name, t, func(ctx context.Context, msg amqp091.Delivery) interface{} {
+		return handle(ctx, h, msg)
+	}, retry)
+	if err == nil {
+		err = c.Start()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe %s: %w", pattern, err)
+	}
+	s := &rabbitSubscription{bus: b, consumer: c}
+	b.subs[s] = struct{}{}
+	return s, nil
+}
+
+// rabbitConsumer consumes queue, which t declares, on RabbitMQ.
+func (b *RabbitBus) rabbitConsumer(queue string, t topology.Topology, handle consumer.HandleFunc, retry consumer.ErrorHandleFunc) (consumer.MessageConsumer, error) {
+	// the queue is declared by the topology, the consumer only consumes it
+	opts := append([]consumer.ConsumerOption{
+		consumer.WithTopology(t),
+		consumer.WithConsumerArgs(consumer.ConsumerArgs{QueueName: queue}),
+	}, b.consumerOpts...)
+	if retry == nil {
+		return consumer.NewReliableRabbitConsumer(b.URL, handle, opts...), nil
+	}
+	c, err := consumer.NewRetryingRabbitConsumer(b.URL, retry, *b.retry, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func handle(ctx context.Context, h Handler, msg amqp091.Delivery) interface{} {
+	e := fromDelivery(msg)
+	err := h(ctx, e)
+	if err == nil {
+		return msg.Ack(false)
+	}
+	log.Error().Err(err).Str("topic", e.Topic).Str("id", e.ID).Msg("event handler failed, rejecting")
+	return msg.Reject(false)
+}
+
+// Close stops all subscriptions, then the publisher.
+func (b *RabbitBus) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	subs := b.subs
+	b.subs = nil
+	b.mu.Unlock()
+
+	for s := range subs {
+		s.consumer.Stop()
+	}
+	b.publisher.Stop()
+	return nil
+}
+
+func (s *rabbitSubscription) Unsubscribe() error {
+	b := s.bus
+	b.mu.Lock()
+	_, ok := b.subs[s]
+	delete(b.subs, s)
+	b.mu.Unlock()
+	if ok {
+		s.consumer.Stop()
+	}
+	return nil
+}
+
+func toPublishing(e Event) amqp091.Publishing {
+	var headers amqp091.Table
+	if len(e.Headers) > 0 {
+		headers = make(amqp091.Table, len(e.Headers))
+		for k, v := range e.Headers {
+			headers[k] = v
+		}
+	}
+	return amqp091.Publishing{
+		Headers:      headers,
+		ContentType:  e.ContentType,
+		DeliveryMode: amqp091.Persistent,
+		MessageId:    e.ID,
+		Timestamp:    e.Timestamp,
+		Body:         e.Body,
+	}
+}
+
+func fromDelivery(msg amqp091.Delivery) Event {
+	var headers map[string]string
+	if len(msg.Headers) > 0 {
+		headers = make(map[string]string, len(msg.Headers))
+		for k, v := range msg.Headers {
+			headers[k] = fmt.Sprint(v)
+		}
+	}
+	// retried messages come back through the default exchange, routed by queue name
+	topic := msg.RoutingKey
+	if key, ok := msg.Headers[consumer.HeaderOriginalRoutingKey].(string); ok {
+		topic = key
+	}
+	return Event{
+		Topic:       topic,
+		ID:          msg.MessageId,
+		Headers:     headers,
+		ContentType: msg.ContentType,
+		Body:        msg.Body,
+		Timestamp:   msg.Timestamp,
+	}
+}
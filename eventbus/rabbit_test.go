@@ -0,0 +1,155 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"github.com/latifrons/latigo/mq/consumer"
+	"github.com/latifrons/latigo/mq/mqtest"
+	"github.com/latifrons/latigo/mq/topology"
+	"github.com/rabbitmq/amqp091-go"
+	"testing"
+	"time"
+)
+
+// testPublisher adapts an mqtest.Publisher to busPublisher.
+type testPublisher struct {
+	*mqtest.Publisher
+}
+
+func (p testPublisher) Start() error {
+	p.Publisher.Start()
+	return nil
+}
+
+// newTestRabbitBus returns a RabbitBus on an mqtest broker instead of RabbitMQ.
+func newTestRabbitBus(t *testing.T, opts ...RabbitOption) (*RabbitBus, *mqtest.Broker) {
+	t.Helper()
+	broker := mqtest.NewBroker()
+	b := NewRabbitBus("amqp://127.0.0.1:1/", "events", opts...)
+	if err := broker.Declare(b.exchangeTopology()); err != nil {
+		t.Fatal(err)
+	}
+	b.publisher = testPublisher{broker.NewPublisher(false)}
+	b.newConsumer = func(queue string, t topology.Topology, handle consumer.HandleFunc, retry consumer.ErrorHandleFunc) (consumer.MessageConsumer, error) {
+		if retry != nil {
+			t = t.Merge(b.retry.Topology(queue))
+			handle = consumer.NewRetryHandleFunc(retry, *b.retry, queue, broker.NewPublisher(true))
+		}
+		if err := broker.Declare(t); err != nil {
+			return nil, err
+		}
+		return broker.NewConsumer(queue, handle, 0), nil
+	}
+	t.Cleanup(func() { _ = b.Close() })
+	return b, broker
+}
+
+func subscribe(t *testing.T, b EventBus, pattern string, group string, h Handler) Subscription {
+	t.Helper()
+	s, err := b.Subscribe(pattern, group, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRabbitBusPublishBeforeStart(t *testing.T) {
+	b, _ := newTestRabbitBus(t)
+	if err := b.Publish(context.Background(), Event{Topic: "order.created"}); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("publish before Start: %v, want ErrNotStarted", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(context.Background(), Event{Topic: "order.created"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("publish after Close: %v, want ErrClosed", err)
+	}
+	if _, err := b.Subscribe("order.*", "", func(ctx context.Context, e Event) error { return nil }); !errors.Is(err, ErrClosed) {
+		t.Errorf("subscribe after Close: %v, want ErrClosed", err)
+	}
+}
+
+func TestRabbitBusDelivers(t *testing.T) {
+	b, broker := newTestRabbitBus(t)
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	received := map[string][]Event{}
+	record := func(name string) Handler {
+		return func(ctx context.Context, e Event) error {
+			received[name] = append(received[name], e)
+			return nil
+		}
+	}
+	subscribe(t, b, "order.*", "", record("private1"))
+	subscribe(t, b, "order.#", "", record("private2"))
+	subscribe(t, b, "order.created", "billing", record("billing1"))
+	subscribe(t, b, "order.created", "billing", record("billing2"))
+	other := subscribe(t, b, "payment.*", "", record("payment"))
+
+	e := Event{Topic: "order.created", Headers: map[string]string{"tenant": "t1"}, Body: []byte("{}")}
+	if err := b.Publish(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	broker.Flush()
+
+	if len(received["private1"]) != 1 || len(received["private2"]) != 1 || len(received["payment"]) != 0 {
+		t.Errorf("received %v, want the event once by each matching subscriber without group", received)
+	}
+	if n := len(received["billing1"]) + len(received["billing2"]); n != 1 {
+		t.Errorf("billing group received %d events, want 1", n)
+	}
+	got := received["private1"][0]
+	if got.Topic != e.Topic || got.ID == "" || got.Headers["tenant"] != "t1" || string(got.Body) != "{}" {
+		t.Errorf("received %+v, want the published event with an ID", got)
+	}
+}
+
+func TestRabbitBusFailedEvents(t *testing.T) {
+	policy := consumer.RetryPolicy{Delays: []time.Duration{time.Second}}
+	b, broker := newTestRabbitBus(t, WithRetryPolicy(policy))
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	failure := func(ctx context.Context, e Event) error { return errors.New("failed") }
+	subscribe(t, b, "order.created", "billing", failure)
+	handled := 0
+	subscribe(t, b, "order.created", "", func(ctx context.Context, e Event) error {
+		handled++
+		return errors.New("failed")
+	})
+
+	if err := b.Publish(context.Background(), Event{Topic: "order.created"}); err != nil {
+		t.Fatal(err)
+	}
+	broker.Flush()
+	if n := broker.QueueLen("billing.retry.1"); n != 1 {
+		t.Errorf("retry queue of the group holds %d, want 1", n)
+	}
+
+	// without a group the failed event is dropped, the group drops it once the retries are exhausted
+	broker.Advance(time.Second)
+	broker.Flush()
+	if handled != 1 {
+		t.Errorf("subscriber without group handled the event %d times, want 1", handled)
+	}
+	if n := broker.QueueLen("billing.retry.1") + broker.QueueLen("billing"); n != 0 {
+		t.Errorf("%d events left after the retries were exhausted, want 0", n)
+	}
+	if n := broker.Unacked(); n != 0 {
+		t.Errorf("%d deliveries left unacked", n)
+	}
+}
+
+func TestFromDeliveryOriginalTopic(t *testing.T) {
+	e := fromDelivery(amqp091.Delivery{
+		RoutingKey: "billing",
+		Headers:    amqp091.Table{consumer.HeaderOriginalRoutingKey: "order.created"},
+	})
+	if e.Topic != "order.created" {
+		t.Errorf("topic of a retried delivery %q, want order.created", e.Topic)
+	}
+}
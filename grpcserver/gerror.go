@@ -52,27 +52,31 @@ func WrapGRpcError(module string, code codes.Code, err error) (errOut error) {
 		return err
 	}
 
+	gerror := ToGError(module, err)
+	if gerror == nil {
+		return nil
+	}
+	return status.New(code, gerror.Error()).Err()
+}
+
+// ToGError converts an error to a *GError as WrapGRpcError does. A *GError is kept as is, a *berror.BError keeps
+// its code and message as a business error, anything else becomes an internal system error.
+func ToGError(module string, err error) *GError {
 	var gerror *GError
 	var berrorx *berror.BError
 
 	switch {
 	case errors.As(err, &gerror):
-		var berr *GError
-		errors.As(err, &berr)
-		if berr == nil {
-			return nil
-		}
-		return status.New(code, gerror.Error()).Err()
+		return gerror
 	case errors.As(err, &berrorx):
 		e := berrorx.Msg
 		if berrorx.CausedBy != nil {
 			e += " caused by: " + berrorx.CausedBy.Error()
 		}
-		return NewGRpcError(module, code, berrorx.Code, berrorx.Msg, e, Category_Business)
+		return NewGError(module, berrorx.Code, berrorx.Msg, e, "", nil, Category_Business)
 	default:
-		return NewGRpcError(module, code, berror.ErrInternal, "", err.Error(), Category_System)
+		return NewGError(module, berror.ErrInternal, "", err.Error(), "", nil, Category_System)
 	}
-
 }
 
 func WrapGRpcErrorLogic(from string, err error) error {
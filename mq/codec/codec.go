@@ -0,0 +1,200 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeMsgpack  = "application/msgpack"
+)
+
+// HeaderSchemaVersion carries the schema version of the encoded payload.
+const HeaderSchemaVersion = "x-schema-version"
+
+// Codec encodes message payloads of one content type.
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+type msgpackCodec struct {
+	handle *codec.MsgpackHandle
+}
+
+func (msgpackCodec) ContentType() string {
+	return ContentTypeMsgpack
+}
+
+func (c msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := codec.NewEncoder(&buf, c.handle).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (c msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, c.handle).Decode(v)
+}
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true
+	h.RawToString = true
+	h.TypeInfos = codec.NewTypeInfos([]string{"msgpack", "json"})
+	return h
+}
+
+var (
+	JSON     Codec = jsonCodec{}
+	Protobuf Codec = protobufCodec{}
+	// Msgpack is a msgpack-compatible binary codec. Struct fields honour `msgpack` and then `json` tags.
+	Msgpack Codec = msgpackCodec{handle: newMsgpackHandle()}
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Codec{
+		ContentTypeJSON:     JSON,
+		ContentTypeProtobuf: Protobuf,
+		ContentTypeMsgpack:  Msgpack,
+	}
+)
+
+// Register makes a codec available to ByContentType, replacing any codec of the same content type.
+func Register(c Codec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[normalize(c.ContentType())] = c
+}
+
+// ByContentType returns the registered codec for the content type, ignoring parameters such as charset.
+func ByContentType(contentType string) (Codec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	c, ok := registry[normalize(contentType)]
+	return c, ok
+}
+
+func normalize(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// SchemaVersion reads the schema version header.
+func SchemaVersion(headers amqp091.Table) string {
+	v, ok := headers[HeaderSchemaVersion]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// Encode marshals v into a Publishing with content type and schema version headers set.
+func Encode(c Codec, v interface{}, schemaVersion string, template amqp091.Publishing) (msg amqp091.Publishing, err error) {
+	msg = template
+	msg.Body, err = c.Marshal(v)
+	if err != nil {
+		return
+	}
+	msg.ContentType = c.ContentType()
+	if schemaVersion != "" {
+		headers := amqp091.Table{}
+		for k, v := range template.Headers {
+			headers[k] = v
+		}
+		headers[HeaderSchemaVersion] = schemaVersion
+		msg.Headers = headers
+	}
+	return
+}
+
+// Decode unmarshals the delivery body into v. The codec is picked from the delivery content type; fallback is used
+// when the content type is empty. Unknown content types are an error.
+func Decode(delivery amqp091.Delivery, fallback Codec, v interface{}) error {
+	c := fallback
+	if delivery.ContentType != "" {
+		var ok bool
+		c, ok = ByContentType(delivery.ContentType)
+		if !ok {
+			return fmt.Errorf("no codec for content type %s", delivery.ContentType)
+		}
+	}
+	if c == nil {
+		return fmt.Errorf("no codec for message without content type")
+	}
+	return c.Unmarshal(delivery.Body, v)
+}
+
+// DecodeAs decodes the delivery into a fresh T like Decode. Pointer types such as *pb.Message get their element
+// allocated.
+func DecodeAs[T any](delivery amqp091.Delivery, fallback Codec) (v T, err error) {
+	rt := reflect.TypeOf((*T)(nil)).Elem()
+	if rt.Kind() == reflect.Pointer {
+		v = reflect.New(rt.Elem()).Interface().(T)
+		err = Decode(delivery, fallback, v)
+		return
+	}
+	err = Decode(delivery, fallback, &v)
+	return
+}
+
+// Truncate cuts s to at most n bytes, for bodies and errors quoted in headers and error messages. It does not cut
+// a UTF-8 sequence in half.
+func Truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
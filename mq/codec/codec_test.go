@@ -0,0 +1,27 @@
+package codec
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"abc", 5, "abc"},
+		{"abcdef", 3, "abc"},
+		// "é" is two bytes, "€" three
+		{"aé", 2, "a"},
+		{"aé", 3, "aé"},
+		{"€€", 5, "€"},
+		{"€", 2, ""},
+	} {
+		got := Truncate(c.s, c.n)
+		if got != c.want || !utf8.ValidString(got) {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", c.s, c.n, got, c.want)
+		}
+	}
+}
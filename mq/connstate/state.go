@@ -0,0 +1,176 @@
+package connstate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type Status int
+
+const (
+	StatusConnecting Status = iota
+	StatusReady
+	StatusUnready
+	StatusClosed
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusConnecting:
+		return "connecting"
+	case StatusReady:
+		return "ready"
+	case StatusUnready:
+		return "unready"
+	case StatusClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+var ErrClosed = errors.New("connection closed")
+
+// State is the connection state of a publisher or consumer.
+type State struct {
+	Status Status
+	// Err is the reason of the last Unready state.
+	Err   error
+	Since time.Time
+}
+
+func (s State) Ready() bool {
+	return s.Status == StatusReady
+}
+
+// Tracker keeps the current state and fans changes out to subscribers.
+type Tracker struct {
+	mu       sync.Mutex
+	state    State
+	subs     map[chan State]struct{}
+	readyCh  chan struct{}
+	closedCh chan struct{}
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		state:    State{Status: StatusConnecting, Since: time.Now()},
+		subs:     make(map[chan State]struct{}),
+		readyCh:  make(chan struct{}),
+		closedCh: make(chan struct{}),
+	}
+}
+
+func (t *Tracker) State() State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+// Set changes the state. Setting the current status again only refreshes Err. Closed is final.
+func (t *Tracker) Set(status Status, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state.Status == StatusClosed {
+		return
+	}
+	if t.state.Status == status {
+		t.state.Err = err
+		return
+	}
+	t.state = State{Status: status, Err: err, Since: time.Now()}
+
+	if status == StatusReady {
+		close(t.readyCh)
+	} else if t.isReadyChClosed() {
+		t.readyCh = make(chan struct{})
+	}
+
+	for sub := range t.subs {
+		// keep only the latest state for slow subscribers
+		select {
+		case sub <- t.state:
+		default:
+			select {
+			case <-sub:
+			default:
+			}
+			sub <- t.state
+		}
+	}
+	if status == StatusClosed {
+		close(t.closedCh)
+		for sub := range t.subs {
+			close(sub)
+		}
+		t.subs = nil
+	}
+}
+
+func (t *Tracker) isReadyChClosed() bool {
+	select {
+	case <-t.readyCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Subscribe returns a channel receiving every state change, starting with the current state.
+// Slow subscribers only see the latest state. The channel is closed on Close or by calling cancel.
+func (t *Tracker) Subscribe() (ch <-chan State, cancel func()) {
+	sub := make(chan State, 1)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sub <- t.state
+	if t.state.Status == StatusClosed {
+		close(sub)
+		return sub, func() {}
+	}
+	t.subs[sub] = struct{}{}
+	return sub, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if _, ok := t.subs[sub]; ok {
+			delete(t.subs, sub)
+			close(sub)
+		}
+	}
+}
+
+// WaitReady blocks until the state is Ready, the tracker is closed or ctx is done.
+func (t *Tracker) WaitReady(ctx context.Context) error {
+	t.mu.Lock()
+	readyCh := t.readyCh
+	closedCh := t.closedCh
+	t.mu.Unlock()
+	select {
+	case <-readyCh:
+		return nil
+	case <-closedCh:
+		return ErrClosed
+	case <-ctx.Done():
+		if err := t.State().Err; err != nil {
+			return fmt.Errorf("%w, last error: %v", ctx.Err(), err)
+		}
+		return ctx.Err()
+	}
+}
+
+// Healthy returns nil when ready, otherwise an error describing the state.
+func (t *Tracker) Healthy() error {
+	state := t.State()
+	if state.Ready() {
+		return nil
+	}
+	if state.Err != nil {
+		return fmt.Errorf("%s since %s: %w", state.Status, state.Since.Format(time.RFC3339), state.Err)
+	}
+	return fmt.Errorf("%s since %s", state.Status, state.Since.Format(time.RFC3339))
+}
+
+func (t *Tracker) Close() {
+	t.Set(StatusClosed, nil)
+}
@@ -0,0 +1,129 @@
+package consumer
+
+import (
+	"context"
+	"github.com/latifrons/amqpextra/consumer"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// BatchHandleFunc handles a batch of deliveries and returns one result per delivery, in the same order.
+// nil acks the delivery, a retryable error (see IsRetryable) requeues it, other errors reject it.
+// Do not ack or nack the deliveries yourself.
+type BatchHandleFunc func(ctx context.Context, msgs []amqp091.Delivery) []error
+
+// BatchWorker is an amqpextra consumer.Worker accumulating up to Size deliveries, or as many as arrived within
+// MaxWait after the first one, before calling Handler. With MaxWait 0 the batch is whatever is already
+// buffered, up to Size.
+type BatchWorker struct {
+	Size    int
+	MaxWait time.Duration
+	Handler BatchHandleFunc
+}
+
+// NewBatchRabbitConsumer creates a consumer handing batches to handler. The prefetch count is raised to size
+// if lower. Middlewares and the worker pool do not apply in batch mode.
+func NewBatchRabbitConsumer(url string, handler BatchHandleFunc, size int, maxWait time.Duration, opts ...ConsumerOption) *ReliableRabbitConsumer {
+	c := NewReliableRabbitConsumer(url, nil, opts...)
+	c.batch = &BatchWorker{
+		Size:    size,
+		MaxWait: maxWait,
+		Handler: handler,
+	}
+	c.HandleFunc = func(ctx context.Context, msg amqp091.Delivery) interface{} {
+		return nil
+	}
+	return c
+}
+
+func (w *BatchWorker) Serve(ctx context.Context, _ consumer.Handler, msgCh <-chan amqp091.Delivery) {
+	size := w.Size
+	if size < 1 {
+		size = 1
+	}
+	// the last batch is still handled when the consumer stops
+	handlerCtx := context.WithoutCancel(ctx)
+
+	batch := make([]amqp091.Delivery, 0, size)
+	var timer *time.Timer
+	var timerCh <-chan time.Time
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, timerCh = nil, nil
+		}
+		if len(batch) == 0 {
+			return
+		}
+		w.handle(handlerCtx, batch)
+		batch = make([]amqp091.Delivery, 0, size)
+	}
+	defer flush()
+
+	for {
+		select {
+		case msg, ok := <-msgCh:
+			if !ok {
+				return
+			}
+			batch = append(batch, msg)
+			if w.MaxWait <= 0 {
+				// take what is already buffered without waiting
+				batch = takeBuffered(msgCh, batch, size)
+				flush()
+			} else if len(batch) >= size {
+				flush()
+			} else if timer == nil {
+				timer = time.NewTimer(w.MaxWait)
+				timerCh = timer.C
+			}
+		case <-timerCh:
+			timer, timerCh = nil, nil
+			flush()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func takeBuffered(msgCh <-chan amqp091.Delivery, batch []amqp091.Delivery, size int) []amqp091.Delivery {
+	for len(batch) < size {
+		select {
+		case msg, ok := <-msgCh:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, msg)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (w *BatchWorker) handle(ctx context.Context, batch []amqp091.Delivery) {
+	results := w.Handler(ctx, batch)
+	if len(results) != len(batch) {
+		log.Error().Int("batch", len(batch)).Int("results", len(results)).Msg("batch handler returned wrong number of results, requeueing batch")
+		for _, msg := range batch {
+			requeue(msg)
+		}
+		return
+	}
+	for i, msg := range batch {
+		var err error
+		switch {
+		case results[i] == nil:
+			err = msg.Ack(false)
+		case IsRetryable(results[i]):
+			err = msg.Nack(false, true)
+		default:
+			log.Warn().Err(results[i]).Str("messageId", msg.MessageId).Msg("rejecting message of batch")
+			err = msg.Reject(false)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("messageId", msg.MessageId).Msg("failed to settle message of batch")
+		}
+	}
+}
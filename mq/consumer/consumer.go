@@ -2,11 +2,17 @@ package consumer
 
 import (
 	"context"
+	"fmt"
 	"github.com/latifrons/amqpextra"
 	"github.com/latifrons/amqpextra/consumer"
 	"github.com/latifrons/amqpextra/logger"
+	"github.com/latifrons/latigo/mq/connstate"
+	"github.com/latifrons/latigo/mq/publisher"
+	"github.com/latifrons/latigo/mq/topology"
 	"github.com/rabbitmq/amqp091-go"
 	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
 )
 
 type ExchangeArgs struct {
@@ -35,17 +41,41 @@ type ConsumerArgs struct {
 
 type ConsumerOption func(*ReliableRabbitConsumer)
 
+// MessageConsumer is the lifecycle surface shared by ReliableRabbitConsumer and test doubles.
+type MessageConsumer interface {
+	Start() error
+	Stop()
+	State() connstate.State
+	WaitReady(ctx context.Context) error
+	Healthy() error
+}
+
+var _ MessageConsumer = (*ReliableRabbitConsumer)(nil)
+
 type ReliableRabbitConsumer struct {
 	URL               string
 	ExchangeArgs      ExchangeArgs
 	DeclaredQueueArgs DeclaredQueueArgs
 	ConsumerArgs      ConsumerArgs
 	HandleFunc        func(ctx context.Context, msg amqp091.Delivery) interface{}
+	Topology          topology.Topology
+	initFunc          func(channel *amqp091.Channel) (err error)
 	logger            logger.Logger
 	dailer            *amqpextra.Dialer
 	consumer          *consumer.Consumer
+	channel           *amqp091.Channel
+	channelMu         sync.RWMutex
 	prefetchCount     int
 	global            bool
+	worker            *PoolWorker
+	batch             *BatchWorker
+	retryPublisher    *publisher.ReliableRabbitPublisher
+	middlewares       []Middleware
+	startTimeout      time.Duration
+	tracker           *connstate.Tracker
+	quit              chan struct{}
+	watcherDone       chan struct{}
+	stopOnce          sync.Once
 }
 
 func NewReliableRabbitConsumer(url string, handleFunc func(ctx context.Context, msg amqp091.Delivery) interface{}, opts ...ConsumerOption) *ReliableRabbitConsumer {
@@ -54,6 +84,7 @@ func NewReliableRabbitConsumer(url string, handleFunc func(ctx context.Context,
 		HandleFunc:    handleFunc,
 		prefetchCount: 1,
 		global:        false,
+		tracker:       connstate.NewTracker(),
 	}
 	for _, opt := range opts {
 		opt(c)
@@ -83,6 +114,20 @@ func WithExchangeArgs(args ExchangeArgs) ConsumerOption {
 	}
 }
 
+// WithTopology declares the given topology on every (re)connect, before the consumed queue is declared and bound.
+func WithTopology(t topology.Topology) ConsumerOption {
+	return func(c *ReliableRabbitConsumer) {
+		c.Topology = c.Topology.Merge(t)
+	}
+}
+
+// WithInitFunc runs f on the consuming channel on every (re)connect, after the topology has been declared.
+func WithInitFunc(f func(channel *amqp091.Channel) (err error)) ConsumerOption {
+	return func(c *ReliableRabbitConsumer) {
+		c.initFunc = f
+	}
+}
+
 func WithQos(prefetchCount int, global bool) ConsumerOption {
 	return func(c *ReliableRabbitConsumer) {
 		c.prefetchCount = prefetchCount
@@ -90,10 +135,79 @@ func WithQos(prefetchCount int, global bool) ConsumerOption {
 	}
 }
 
+// WithWorkerPool handles messages on num goroutines with at most maxInFlight messages dispatched at once.
+// The prefetch count is raised to maxInFlight if lower. Without WithWorkerPool, WithOrderingKey or
+// WithDrainTimeout messages are handled one at a time by amqpextra's DefaultWorker.
+func WithWorkerPool(num int, maxInFlight int) ConsumerOption {
+	return func(c *ReliableRabbitConsumer) {
+		c.pool().Num = num
+		c.pool().MaxInFlight = maxInFlight
+	}
+}
+
+// WithOrderingKey makes messages with the same key be handled sequentially by the same pool goroutine.
+func WithOrderingKey(keyFunc KeyFunc) ConsumerOption {
+	return func(c *ReliableRabbitConsumer) {
+		c.pool().KeyFunc = keyFunc
+	}
+}
+
+// WithDrainTimeout bounds how long Stop waits for in-flight messages before cancelling their context.
+func WithDrainTimeout(timeout time.Duration) ConsumerOption {
+	return func(c *ReliableRabbitConsumer) {
+		c.pool().DrainTimeout = timeout
+	}
+}
+
+// WithStartTimeout makes Start fail if the consumer is not ready within timeout. 0 returns immediately and keeps
+// retrying in the background.
+func WithStartTimeout(timeout time.Duration) ConsumerOption {
+	return func(c *ReliableRabbitConsumer) {
+		c.startTimeout = timeout
+	}
+}
+
+// newWorker returns the configured worker and raises the prefetch count to what it can take, or nil for amqpextra's
+// DefaultWorker, which handles one delivery at a time on one goroutine.
+func (c *ReliableRabbitConsumer) newWorker() consumer.Worker {
+	if c.batch != nil {
+		if c.prefetchCount < c.batch.Size {
+			c.prefetchCount = c.batch.Size
+		}
+		return c.batch
+	}
+	if c.worker != nil {
+		if c.prefetchCount < c.worker.MaxInFlight {
+			c.prefetchCount = c.worker.MaxInFlight
+		}
+		return c.worker
+	}
+	return nil
+}
+
+func (c *ReliableRabbitConsumer) pool() *PoolWorker {
+	if c.worker == nil {
+		c.worker = &PoolWorker{Num: 1}
+	}
+	return c.worker
+}
+
 func (c *ReliableRabbitConsumer) Start() (err error) {
 	//ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
 	//defer cancelFunc()
 
+	if c.retryPublisher != nil {
+		err = c.retryPublisher.Start()
+		if err != nil {
+			return fmt.Errorf("retry publisher: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				c.retryPublisher.Stop()
+			}
+		}()
+	}
+
 	dialerChannel := make(chan amqpextra.State, 10)
 	consumerChannel := make(chan consumer.State, 10)
 
@@ -107,13 +221,21 @@ func (c *ReliableRabbitConsumer) Start() (err error) {
 		return
 	}
 
-	h := consumer.HandlerFunc(c.HandleFunc)
+	h := consumer.HandlerFunc(Chain(c.HandleFunc, c.middlewares...))
+
+	worker := c.newWorker()
+
+	// consume an existing queue when only ConsumerArgs.QueueName is given, otherwise declare it
+	queueOpt := consumer.WithDeclareQueue(c.DeclaredQueueArgs.Name, c.DeclaredQueueArgs.Durable, c.DeclaredQueueArgs.AutoDelete, c.DeclaredQueueArgs.Exclusive, c.DeclaredQueueArgs.NoWait, c.DeclaredQueueArgs.Args)
+	if c.DeclaredQueueArgs.Name == "" && c.ConsumerArgs.QueueName != "" {
+		queueOpt = consumer.WithQueue(c.ConsumerArgs.QueueName)
+	}
 
-	c.consumer, err = c.dailer.Consumer(
+	consumerOpts := []consumer.Option{
 		consumer.WithNotify(consumerChannel),
+		consumer.WithInitFunc(c.initer),
 		consumer.WithExchange(c.ExchangeArgs.ExchangeName, c.ExchangeArgs.RoutingKey),
-		consumer.WithDeclareQueue(c.DeclaredQueueArgs.Name, c.DeclaredQueueArgs.Durable, c.DeclaredQueueArgs.AutoDelete, c.DeclaredQueueArgs.Exclusive, c.DeclaredQueueArgs.NoWait, c.DeclaredQueueArgs.Args),
-		//consumer.WithQueue(c.ConsumerArgs.QueueName),
+		queueOpt,
 		consumer.WithLogger(c.logger),
 		consumer.WithHandler(h),
 		consumer.WithQos(c.prefetchCount, c.global),
@@ -122,23 +244,134 @@ func (c *ReliableRabbitConsumer) Start() (err error) {
 			c.ConsumerArgs.Exclusive,
 			c.ConsumerArgs.NoLocal,
 			c.ConsumerArgs.NoWait,
-			c.ConsumerArgs.Args))
-
-	go func() {
-		for {
-			select {
-			case v := <-dialerChannel:
-				log.Info().Interface("v", v).Msg("dialer updates")
-			case v := <-consumerChannel:
-				log.Info().Interface("v", v).Msg("consumer updates")
+			c.ConsumerArgs.Args),
+	}
+	if worker != nil {
+		consumerOpts = append(consumerOpts, consumer.WithWorker(worker))
+	}
+	c.consumer, err = c.dailer.Consumer(consumerOpts...)
+	if err != nil {
+		c.dailer.Close()
+		return
+	}
+
+	c.quit = make(chan struct{})
+	c.watcherDone = make(chan struct{})
+	go c.watch(dialerChannel, consumerChannel)
+
+	if c.startTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), c.startTimeout)
+		defer cancel()
+		err = c.WaitReady(ctx)
+		if err != nil {
+			c.Stop()
+			return fmt.Errorf("consumer not ready within %s: %w", c.startTimeout, err)
+		}
+	}
+	return
+}
+
+func (c *ReliableRabbitConsumer) watch(dialerChannel chan amqpextra.State, consumerChannel chan consumer.State) {
+	defer close(c.watcherDone)
+	for {
+		select {
+		case v := <-dialerChannel:
+			log.Info().Interface("v", v).Msg("dialer updates")
+			if v.Unready != nil {
+				c.tracker.Set(connstate.StatusUnready, v.Unready.Err)
+			}
+		case v := <-consumerChannel:
+			log.Info().Interface("v", v).Msg("consumer updates")
+			if v.Ready != nil {
+				c.tracker.Set(connstate.StatusReady, nil)
+			} else if v.Unready != nil {
+				c.tracker.Set(connstate.StatusUnready, v.Unready.Err)
 			}
+		case <-c.quit:
+			return
 		}
+	}
+}
 
-	}()
-	return
+// State returns the current connection state.
+func (c *ReliableRabbitConsumer) State() connstate.State {
+	return c.tracker.State()
+}
+
+// Subscribe returns a channel of connection state changes. Call cancel when no longer interested.
+func (c *ReliableRabbitConsumer) Subscribe() (<-chan connstate.State, func()) {
+	return c.tracker.Subscribe()
+}
+
+// WaitReady blocks until the consumer is consuming, it is stopped or ctx is done.
+func (c *ReliableRabbitConsumer) WaitReady(ctx context.Context) error {
+	return c.tracker.WaitReady(ctx)
+}
+
+// Healthy returns nil while the consumer is consuming.
+func (c *ReliableRabbitConsumer) Healthy() error {
+	return c.tracker.Healthy()
 }
 
+func (c *ReliableRabbitConsumer) initer(conn consumer.AMQPConnection) (channelC consumer.AMQPChannel, err error) {
+	channel, err := conn.(*amqp091.Connection).Channel()
+	if err != nil {
+		return
+	}
+	err = c.Topology.Declare(channel)
+	if err != nil {
+		_ = channel.Close()
+		return
+	}
+	if c.initFunc != nil {
+		err = c.initFunc(channel)
+		if err != nil {
+			_ = channel.Close()
+			return
+		}
+	}
+	c.channelMu.Lock()
+	c.channel = channel
+	c.channelMu.Unlock()
+	return channel, nil
+}
+
+// Channel returns the current consuming channel, nil before the first connect.
+func (c *ReliableRabbitConsumer) Channel() *amqp091.Channel {
+	c.channelMu.RLock()
+	defer c.channelMu.RUnlock()
+	return c.channel
+}
+
+// queueName is the name of the consumed queue.
+func (c *ReliableRabbitConsumer) queueName() string {
+	if c.DeclaredQueueArgs.Name != "" {
+		return c.DeclaredQueueArgs.Name
+	}
+	return c.ConsumerArgs.QueueName
+}
+
+// Stop stops consuming and waits for in-flight messages to be handled. It may be called more than once, also after
+// Start failed or without Start. A stopped consumer cannot be started again.
 func (c *ReliableRabbitConsumer) Stop() {
-	c.consumer.Close()
-	c.dailer.Close()
+	c.stopOnce.Do(c.stop)
+}
+
+func (c *ReliableRabbitConsumer) stop() {
+	if c.consumer != nil {
+		c.consumer.Close()
+		<-c.consumer.NotifyClosed()
+	}
+	if c.quit != nil {
+		close(c.quit)
+		<-c.watcherDone
+	}
+	// in-flight retries are published by now
+	if c.retryPublisher != nil {
+		c.retryPublisher.Stop()
+	}
+	c.tracker.Close()
+	if c.dailer != nil {
+		c.dailer.Close()
+	}
 }
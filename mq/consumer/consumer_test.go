@@ -0,0 +1,68 @@
+package consumer
+
+import (
+	"context"
+	"github.com/latifrons/amqpextra/logger"
+	"github.com/latifrons/latigo/mq/connstate"
+	"github.com/rabbitmq/amqp091-go"
+	"testing"
+	"time"
+)
+
+func TestStopWithoutStart(t *testing.T) {
+	c := NewReliableRabbitConsumer("amqp://127.0.0.1:1/", func(ctx context.Context, msg amqp091.Delivery) interface{} {
+		return nil
+	}, WithLogger(logger.Discard))
+	c.Stop()
+	c.Stop()
+	if c.State().Status != connstate.StatusClosed {
+		t.Errorf("state %v after Stop, want closed", c.State())
+	}
+}
+
+func TestStopAfterStartTimeout(t *testing.T) {
+	c := NewReliableRabbitConsumer("amqp://127.0.0.1:1/", func(ctx context.Context, msg amqp091.Delivery) interface{} {
+		return nil
+	}, WithLogger(logger.Discard), WithStartTimeout(50*time.Millisecond), WithDeclaredQueueArgs(DeclaredQueueArgs{Name: "orders"}))
+	if err := c.Start(); err == nil {
+		t.Fatal("started without a broker")
+	}
+	// Start already stopped the consumer
+	c.Stop()
+}
+
+func TestRetryingConsumerNeedsQueue(t *testing.T) {
+	handler := func(ctx context.Context, msg amqp091.Delivery) error { return nil }
+	policy := RetryPolicy{Delays: []time.Duration{time.Second}}
+	if _, err := NewRetryingRabbitConsumer("amqp://127.0.0.1:1/", handler, policy, WithLogger(logger.Discard)); err == nil {
+		t.Error("created a retrying consumer without a queue name")
+	}
+	c, err := NewRetryingRabbitConsumer("amqp://127.0.0.1:1/", handler, policy, WithLogger(logger.Discard),
+		WithConsumerArgs(ConsumerArgs{QueueName: "orders"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Topology.Queues) == 0 || c.Topology.Queues[0].Name != "orders.retry.1" {
+		t.Errorf("retry topology %+v, want the delay queue orders.retry.1", c.Topology.Queues)
+	}
+	c.Stop()
+}
+
+func TestDefaultWorkerWithoutPoolOptions(t *testing.T) {
+	handle := func(ctx context.Context, msg amqp091.Delivery) interface{} { return nil }
+	c := NewReliableRabbitConsumer("amqp://127.0.0.1:1/", handle, WithQos(5, false))
+	if w := c.newWorker(); w != nil {
+		t.Errorf("worker %T without pool options, want amqpextra's DefaultWorker", w)
+	}
+	if c.prefetchCount != 5 {
+		t.Errorf("prefetch %d, want 5", c.prefetchCount)
+	}
+
+	c = NewReliableRabbitConsumer("amqp://127.0.0.1:1/", handle, WithOrderingKey(KeyFromRoutingKey()), WithWorkerPool(4, 16))
+	if w, ok := c.newWorker().(*PoolWorker); !ok || w.Num != 4 || w.KeyFunc == nil {
+		t.Errorf("worker %#v, want the configured pool", c.newWorker())
+	}
+	if c.prefetchCount != 16 {
+		t.Errorf("prefetch %d, want it raised to the in-flight limit 16", c.prefetchCount)
+	}
+}
@@ -0,0 +1,113 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"github.com/latifrons/latigo/mq/correlation"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"runtime/debug"
+	"time"
+)
+
+type HandleFunc func(ctx context.Context, msg amqp091.Delivery) interface{}
+
+// Middleware wraps a HandleFunc.
+type Middleware func(next HandleFunc) HandleFunc
+
+// Chain wraps h with the middlewares. The first middleware is the outermost one.
+func Chain(h HandleFunc, middlewares ...Middleware) HandleFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// WithMiddleware appends middlewares around HandleFunc. They are applied on Start.
+func WithMiddleware(middlewares ...Middleware) ConsumerOption {
+	return func(c *ReliableRabbitConsumer) {
+		c.middlewares = append(c.middlewares, middlewares...)
+	}
+}
+
+// RecoverMiddleware turns a handler panic into a nack. Without requeue the message goes to the dead-letter
+// exchange of the queue if there is one.
+func RecoverMiddleware(requeue bool) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, msg amqp091.Delivery) (result interface{}) {
+			defer func() {
+				if p := recover(); p != nil {
+					logger := ctxLogger(ctx)
+					logger.Error().Interface("panic", p).Str("stack", string(debug.Stack())).
+						Str("messageId", msg.MessageId).Msg("panic in message handler")
+					if err := msg.Nack(false, requeue); err != nil {
+						logger.Error().Err(err).Msg("failed to nack message after panic")
+					}
+					result = nil
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// ctxLogger is the logger of LoggingMiddleware in ctx, or the global logger when there is none.
+func ctxLogger(ctx context.Context) *zerolog.Logger {
+	logger := zerolog.Ctx(ctx)
+	if logger.GetLevel() == zerolog.Disabled {
+		return &log.Logger
+	}
+	return logger
+}
+
+// TimeoutMiddleware cancels the handler context after timeout. The handler must honour ctx.
+func TimeoutMiddleware(timeout time.Duration) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, msg amqp091.Delivery) interface{} {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// CorrelationMiddleware puts the correlation id of the delivery into the handler context, generating one when absent.
+func CorrelationMiddleware() Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, msg amqp091.Delivery) interface{} {
+			id := correlation.FromDelivery(msg)
+			if id == "" {
+				id = correlation.NewID()
+			}
+			return next(correlation.NewContext(ctx, id), msg)
+		}
+	}
+}
+
+// LoggingMiddleware attaches a zerolog logger with the delivery metadata to the handler context,
+// retrievable with zerolog.Ctx, and logs each handled message at the given level.
+func LoggingMiddleware(level zerolog.Level) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, msg amqp091.Delivery) interface{} {
+			lc := log.With().
+				Str("exchange", msg.Exchange).
+				Str("routingKey", msg.RoutingKey).
+				Str("messageId", msg.MessageId).
+				Uint64("deliveryTag", msg.DeliveryTag).
+				Bool("redelivered", msg.Redelivered)
+			if id := correlation.FromContext(ctx); id != "" {
+				lc = lc.Str("correlationId", id)
+			}
+			logger := lc.Logger()
+			start := time.Now()
+			result := next(logger.WithContext(ctx), msg)
+			event := logger.WithLevel(level).Dur("elapsed", time.Since(start))
+			if result != nil {
+				event = event.Str("result", fmt.Sprintf("%v", result))
+			}
+			event.Msg("message handled")
+			return result
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/latifrons/latigo/berror"
+	"github.com/latifrons/latigo/mq/codec"
+	"github.com/latifrons/latigo/mq/publisher"
+	"github.com/latifrons/latigo/mq/topology"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	// HeaderRetryAttempt counts how many times the message has been retried.
+	HeaderRetryAttempt = "x-retry-attempt"
+	// HeaderLastError carries the error of the last failed attempt.
+	HeaderLastError = "x-last-error"
+	// HeaderOriginalRoutingKey keeps the routing key the message was first delivered with.
+	HeaderOriginalRoutingKey = "x-original-routing-key"
+)
+
+// ErrorHandleFunc is the handler contract for retrying consumers: return nil to ack, an error to retry or
+// dead-letter. Do not ack or nack the delivery yourself.
+type ErrorHandleFunc func(ctx context.Context, msg amqp091.Delivery) error
+
+// RetryPolicy configures delayed retries and dead-lettering.
+//
+// Each retry is parked in a delay queue "<queue>.retry.<n>" whose TTL is Delays[n-1]. When the TTL expires the
+// broker dead-letters it back to the consumed queue through the default exchange. After len(Delays) retries, or
+// immediately for berror.CategoryBusinessFail errors, the message is published to DeadLetterExchange with the
+// queue name as routing key and lands in "<queue>.dead".
+type RetryPolicy struct {
+	Delays             []time.Duration
+	DeadLetterExchange string
+	// Durable applies to the delay queues, the dead letter exchange and the dead letter queue.
+	Durable bool
+}
+
+func (p RetryPolicy) MaxAttempts() int {
+	return len(p.Delays) + 1
+}
+
+func (p RetryPolicy) delayQueueName(queue string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", queue, attempt)
+}
+
+func (p RetryPolicy) deadQueueName(queue string) string {
+	return queue + ".dead"
+}
+
+// Topology returns the delay queues, dead letter exchange and dead letter queue for the consumed queue.
+func (p RetryPolicy) Topology(queue string) topology.Topology {
+	t := topology.Topology{}
+	for i, delay := range p.Delays {
+		t.Queues = append(t.Queues, topology.Queue{
+			Name:                 p.delayQueueName(queue, i+1),
+			Durable:              p.Durable,
+			DeadLetterRoutingKey: queue,
+			MessageTTL:           delay,
+			// dead-letter to the default exchange, which routes by queue name
+			Args: amqp091.Table{"x-dead-letter-exchange": ""},
+		})
+	}
+	if p.DeadLetterExchange != "" {
+		t.Exchanges = append(t.Exchanges, topology.Exchange{
+			Name:    p.DeadLetterExchange,
+			Kind:    amqp091.ExchangeDirect,
+			Durable: p.Durable,
+		})
+		t.Queues = append(t.Queues, topology.Queue{
+			Name:    p.deadQueueName(queue),
+			Durable: p.Durable,
+		})
+		t.Bindings = append(t.Bindings, topology.Binding{
+			Queue:      p.deadQueueName(queue),
+			Exchange:   p.DeadLetterExchange,
+			RoutingKey: queue,
+		})
+	}
+	return t
+}
+
+// IsRetryable reports whether err is worth retrying. Business failures are not; every other error,
+// including errors that are not a *berror.BError, is treated as temporary.
+func IsRetryable(err error) bool {
+	var berr *berror.BError
+	if errors.As(err, &berr) {
+		return berr.ErrorCategory != berror.CategoryBusinessFail
+	}
+	return true
+}
+
+// NewRetryingRabbitConsumer creates a consumer whose handler returns an error. Successful messages are acked,
+// failures are retried or dead-lettered according to policy. The retry topology is declared for the consumed queue,
+// so its name must be set by the options. Retries and dead letters go through a mandatory publisher in confirm mode
+// on a connection of its own, started and stopped with the consumer.
+func NewRetryingRabbitConsumer(url string, handler ErrorHandleFunc, policy RetryPolicy, opts ...ConsumerOption) (*ReliableRabbitConsumer, error) {
+	c := NewReliableRabbitConsumer(url, nil, opts...)
+	queue := c.queueName()
+	if queue == "" {
+		return nil, fmt.Errorf("retrying consumer needs a queue name")
+	}
+	publisherOpts := []publisher.PublisherOption{
+		publisher.WithConfirmMode(RetryConfirmBuffer),
+		publisher.WithMandatory(true),
+		publisher.WithStartTimeout(c.startTimeout),
+	}
+	if c.logger != nil {
+		publisherOpts = append(publisherOpts, publisher.WithLogger(c.logger))
+	}
+	c.retryPublisher = publisher.NewReliableRabbitPublisher(url, publisherOpts...)
+	c.HandleFunc = NewRetryHandleFunc(handler, policy, queue, c.retryPublisher)
+	c.Topology = c.Topology.Merge(policy.Topology(queue))
+	return c, nil
+}
+
+// RetryConfirmBuffer bounds the unconfirmed retries and dead letters of a retrying consumer.
+const RetryConfirmBuffer = 100
+
+// ChannelPublisher publishes on an AMQP channel. *amqp091.Channel implements it.
+type ChannelPublisher interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp091.Publishing) error
+}
+
+// NewRetryHandleFunc adapts an ErrorHandleFunc consuming queue to the retry policy. Retries and dead letters are
+// published with p, which should confirm them and fail for unroutable ones, like a publisher.ReliableRabbitPublisher
+// with WithConfirmMode and WithMandatory. The failed delivery is acked once p returns nil, else nacked and requeued.
+func NewRetryHandleFunc(handler ErrorHandleFunc, policy RetryPolicy, queue string, p publisher.MessagePublisher) HandleFunc {
+	r := &retrier{
+		publisher: p,
+		handler:   handler,
+		policy:    policy,
+		queue:     queue,
+	}
+	return r.handle
+}
+
+type retrier struct {
+	publisher publisher.MessagePublisher
+	handler   ErrorHandleFunc
+	policy    RetryPolicy
+	queue     string
+}
+
+func (r *retrier) handle(ctx context.Context, msg amqp091.Delivery) interface{} {
+	err := r.handler(ctx, msg)
+	if err == nil {
+		return msg.Ack(false)
+	}
+
+	attempt := RetryAttempt(msg)
+	logger := log.With().Err(err).Str("queue", r.queue).Str("messageId", msg.MessageId).Int("attempt", attempt).Logger()
+	if IsRetryable(err) && attempt < len(r.policy.Delays) {
+		errx := r.republish(ctx, msg, "", r.policy.delayQueueName(r.queue, attempt+1), attempt+1, err)
+		if errx != nil {
+			logger.Error().AnErr("publishErr", errx).Msg("failed to schedule retry, requeueing")
+			return msg.Nack(false, true)
+		}
+		logger.Warn().Msg("message handling failed, retry scheduled")
+		return msg.Ack(false)
+	}
+
+	if r.policy.DeadLetterExchange == "" {
+		logger.Error().Msg("message handling failed, rejecting")
+		return msg.Reject(false)
+	}
+	errx := r.republish(ctx, msg, r.policy.DeadLetterExchange, r.queue, attempt, err)
+	if errx != nil {
+		logger.Error().AnErr("publishErr", errx).Msg("failed to dead-letter message, requeueing")
+		return msg.Nack(false, true)
+	}
+	logger.Error().Msg("message handling failed, dead-lettered")
+	return msg.Ack(false)
+}
+
+func (r *retrier) republish(ctx context.Context, msg amqp091.Delivery, exchange string, key string, attempt int, cause error) error {
+	p := DeliveryToPublishing(msg)
+	p.Headers[HeaderRetryAttempt] = int32(attempt)
+	p.Headers[HeaderLastError] = codec.Truncate(cause.Error(), 1024)
+	if _, ok := p.Headers[HeaderOriginalRoutingKey]; !ok {
+		p.Headers[HeaderOriginalRoutingKey] = msg.RoutingKey
+	}
+	return r.publisher.Publish(ctx, exchange, key, p)
+}
+
+// RetryAttempt returns how many times the delivery has already been retried.
+func RetryAttempt(msg amqp091.Delivery) int {
+	switch v := msg.Headers[HeaderRetryAttempt].(type) {
+	case int:
+		return v
+	case int8:
+		return int(v)
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// DeliveryToPublishing copies body and properties of a delivery so it can be published again.
+// Headers are always non-nil in the result.
+func DeliveryToPublishing(msg amqp091.Delivery) amqp091.Publishing {
+	headers := amqp091.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	return amqp091.Publishing{
+		Headers:         headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	}
+}
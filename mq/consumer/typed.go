@@ -0,0 +1,55 @@
+package consumer
+
+import (
+	"context"
+	"github.com/latifrons/latigo/mq/codec"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+)
+
+// TypedHandleFunc handles a decoded message. The delivery is passed along for acking and metadata.
+type TypedHandleFunc[T any] func(ctx context.Context, msg T, delivery amqp091.Delivery) interface{}
+
+// PoisonHandleFunc handles a delivery whose body could not be decoded.
+type PoisonHandleFunc func(ctx context.Context, delivery amqp091.Delivery, err error) interface{}
+
+// RejectPoison is the default poison handler: it logs the failure and rejects the message without requeue,
+// so it goes to the dead-letter exchange of the queue if there is one.
+func RejectPoison(ctx context.Context, delivery amqp091.Delivery, err error) interface{} {
+	log.Error().Err(err).Str("contentType", delivery.ContentType).Str("messageId", delivery.MessageId).
+		Str("routingKey", delivery.RoutingKey).Msg("failed to decode message")
+	if errx := delivery.Reject(false); errx != nil {
+		log.Error().Err(errx).Msg("failed to reject poison message")
+	}
+	return nil
+}
+
+// TypedConsumer decodes deliveries into T before handing them to the business handler.
+// The codec is selected by the delivery content type; Codec is used for messages without one.
+type TypedConsumer[T any] struct {
+	*ReliableRabbitConsumer
+	Codec        codec.Codec
+	Handler      TypedHandleFunc[T]
+	PoisonHandle PoisonHandleFunc
+}
+
+func NewTypedConsumer[T any](url string, c codec.Codec, handler TypedHandleFunc[T], poison PoisonHandleFunc, opts ...ConsumerOption) *TypedConsumer[T] {
+	if poison == nil {
+		poison = RejectPoison
+	}
+	t := &TypedConsumer[T]{
+		Codec:        c,
+		Handler:      handler,
+		PoisonHandle: poison,
+	}
+	t.ReliableRabbitConsumer = NewReliableRabbitConsumer(url, t.handle, opts...)
+	return t
+}
+
+func (t *TypedConsumer[T]) handle(ctx context.Context, delivery amqp091.Delivery) interface{} {
+	v, err := codec.DecodeAs[T](delivery, t.Codec)
+	if err != nil {
+		return t.PoisonHandle(ctx, delivery, err)
+	}
+	return t.Handler(ctx, v, delivery)
+}
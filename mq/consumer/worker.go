@@ -0,0 +1,177 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"github.com/latifrons/amqpextra/consumer"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// KeyFunc extracts the ordering key of a delivery. Deliveries with the same key are handled one at a time,
+// in arrival order.
+type KeyFunc func(msg amqp091.Delivery) string
+
+// KeyFromHeader orders by the value of a header. Deliveries without the header are not ordered.
+func KeyFromHeader(name string) KeyFunc {
+	return func(msg amqp091.Delivery) string {
+		v, ok := msg.Headers[name]
+		if !ok || v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+}
+
+// KeyFromRoutingKey orders by routing key.
+func KeyFromRoutingKey() KeyFunc {
+	return func(msg amqp091.Delivery) string {
+		return msg.RoutingKey
+	}
+}
+
+// PoolWorker is an amqpextra consumer.Worker running handlers on a fixed number of goroutines.
+//
+// At most MaxInFlight deliveries are dispatched and not yet handled. With a KeyFunc, deliveries with the same
+// non-empty key always go to the same goroutine. Each delivery is acked by its own handler with its own delivery
+// tag, so acks from different goroutines never cover each other.
+//
+// When the consumer stops, no new deliveries are accepted and the ones already dispatched are handled to completion.
+// After DrainTimeout the handler context is cancelled.
+type PoolWorker struct {
+	Num          int
+	MaxInFlight  int
+	KeyFunc      KeyFunc
+	DrainTimeout time.Duration
+}
+
+func (w *PoolWorker) Serve(ctx context.Context, h consumer.Handler, msgCh <-chan amqp091.Delivery) {
+	num := w.Num
+	if num < 1 {
+		num = 1
+	}
+	maxInFlight := w.MaxInFlight
+	if maxInFlight < num {
+		maxInFlight = num
+	}
+
+	// handlers outlive the consumer context so that in-flight messages can finish during drain
+	handlerCtx, cancelHandlers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelHandlers()
+
+	sem := make(chan struct{}, maxInFlight)
+	shared := make(chan amqp091.Delivery)
+	keyed := make([]chan amqp091.Delivery, num)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < num; i++ {
+		keyed[i] = make(chan amqp091.Delivery, maxInFlight)
+		wg.Add(1)
+		go func(own <-chan amqp091.Delivery) {
+			defer wg.Done()
+			sharedCh := (<-chan amqp091.Delivery)(shared)
+			for own != nil || sharedCh != nil {
+				var msg amqp091.Delivery
+				var ok bool
+				select {
+				case msg, ok = <-own:
+					if !ok {
+						own = nil
+						continue
+					}
+				case msg, ok = <-sharedCh:
+					if !ok {
+						sharedCh = nil
+						continue
+					}
+				}
+				if res := h.Handle(handlerCtx, msg); res != nil {
+					log.Error().Interface("result", res).Str("messageId", msg.MessageId).Msg("handler returned non nil result")
+				}
+				<-sem
+			}
+		}(keyed[i])
+	}
+
+	w.dispatch(ctx, msgCh, sem, shared, keyed)
+
+	close(shared)
+	for _, ch := range keyed {
+		close(ch)
+	}
+	w.drain(wg, cancelHandlers)
+}
+
+func (w *PoolWorker) dispatch(ctx context.Context, msgCh <-chan amqp091.Delivery, sem chan struct{}, shared chan amqp091.Delivery, keyed []chan amqp091.Delivery) {
+	for {
+		var msg amqp091.Delivery
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok = <-msgCh:
+			if !ok {
+				return
+			}
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			requeue(msg)
+			return
+		}
+
+		key := ""
+		if w.KeyFunc != nil {
+			key = w.KeyFunc(msg)
+		}
+		if key != "" {
+			// never blocks: the buffer is as large as the in-flight limit
+			keyed[hashKey(key)%uint32(len(keyed))] <- msg
+			continue
+		}
+		select {
+		case shared <- msg:
+		case <-ctx.Done():
+			<-sem
+			requeue(msg)
+			return
+		}
+	}
+}
+
+func (w *PoolWorker) drain(wg *sync.WaitGroup, cancelHandlers context.CancelFunc) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	if w.DrainTimeout <= 0 {
+		<-done
+		return
+	}
+	timer := time.NewTimer(w.DrainTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		log.Warn().Dur("timeout", w.DrainTimeout).Msg("drain timeout, cancelling in-flight handlers")
+		cancelHandlers()
+		<-done
+	}
+}
+
+func requeue(msg amqp091.Delivery) {
+	if err := msg.Nack(false, true); err != nil {
+		log.Warn().Err(err).Str("messageId", msg.MessageId).Msg("failed to requeue message")
+	}
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32()
+}
@@ -0,0 +1,163 @@
+package consumer_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/latifrons/amqpextra/consumer"
+	mqconsumer "github.com/latifrons/latigo/mq/consumer"
+	"github.com/latifrons/latigo/mq/mqtest"
+	"github.com/latifrons/latigo/mq/topology"
+	"github.com/rabbitmq/amqp091-go"
+	"sync"
+	"testing"
+	"time"
+)
+
+const workerQueue = "work"
+
+// deliver publishes the messages to an mqtest broker and returns their deliveries, still unacked, on a channel.
+func deliver(t *testing.T, msgs ...amqp091.Publishing) (*mqtest.Broker, chan amqp091.Delivery) {
+	t.Helper()
+	b := mqtest.NewBroker()
+	if err := b.Declare(topology.Topology{Queues: []topology.Queue{{Name: workerQueue}}}); err != nil {
+		t.Fatal(err)
+	}
+	msgCh := make(chan amqp091.Delivery, len(msgs))
+	c := b.NewConsumer(workerQueue, func(ctx context.Context, msg amqp091.Delivery) interface{} {
+		msgCh <- msg
+		return nil
+	}, 0)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	p := b.NewPublisher(true)
+	for _, msg := range msgs {
+		if err := p.Publish(context.Background(), "", workerQueue, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := b.Flush(); n != len(msgs) {
+		t.Fatalf("delivered %d, want %d", n, len(msgs))
+	}
+	return b, msgCh
+}
+
+// serve runs the worker until it returns.
+func serve(w consumer.Worker, ctx context.Context, h func(ctx context.Context, msg amqp091.Delivery) interface{}, msgCh chan amqp091.Delivery) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Serve(ctx, consumer.HandlerFunc(h), msgCh)
+	}()
+	return done
+}
+
+func wait(t *testing.T, done chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker did not return")
+	}
+}
+
+func TestPoolWorkerOrdersByKey(t *testing.T) {
+	var msgs []amqp091.Publishing
+	for i := 0; i < 30; i++ {
+		msgs = append(msgs, amqp091.Publishing{
+			MessageId: fmt.Sprint(i),
+			Headers:   amqp091.Table{"k": fmt.Sprint("key", i%3)},
+		})
+	}
+	b, msgCh := deliver(t, msgs...)
+	close(msgCh)
+
+	var mu sync.Mutex
+	seen := map[string][]string{}
+	running := map[string]bool{}
+	w := &mqconsumer.PoolWorker{Num: 4, MaxInFlight: 8, KeyFunc: mqconsumer.KeyFromHeader("k")}
+	done := serve(w, context.Background(), func(ctx context.Context, msg amqp091.Delivery) interface{} {
+		key := msg.Headers["k"].(string)
+		mu.Lock()
+		if running[key] {
+			t.Errorf("two messages of %s handled at once", key)
+		}
+		running[key] = true
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running[key] = false
+		seen[key] = append(seen[key], msg.MessageId)
+		mu.Unlock()
+		return msg.Ack(false)
+	}, msgCh)
+	wait(t, done)
+
+	for key, ids := range seen {
+		for i := 1; i < len(ids); i++ {
+			var prev, cur int
+			fmt.Sscan(ids[i-1], &prev)
+			fmt.Sscan(ids[i], &cur)
+			if prev > cur {
+				t.Errorf("%s handled out of order: %v", key, ids)
+				break
+			}
+		}
+	}
+	if len(seen) != 3 || b.Unacked() != 0 {
+		t.Errorf("handled keys %v with %d unacked, want 3 keys all acked", seen, b.Unacked())
+	}
+}
+
+func TestPoolWorkerDrainTimeout(t *testing.T) {
+	b, msgCh := deliver(t, amqp091.Publishing{MessageId: "m1"})
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	w := &mqconsumer.PoolWorker{Num: 1, DrainTimeout: 50 * time.Millisecond}
+	done := serve(w, ctx, func(ctx context.Context, msg amqp091.Delivery) interface{} {
+		close(started)
+		// a handler that only gives up when its context is cancelled
+		<-ctx.Done()
+		return msg.Nack(false, true)
+	}, msgCh)
+
+	<-started
+	cancel()
+	wait(t, done)
+	if n := b.QueueLen(workerQueue); n != 1 || b.Unacked() != 0 {
+		t.Errorf("queue holds %d with %d unacked after the drain timeout, want the message requeued", n, b.Unacked())
+	}
+}
+
+func TestPoolWorkerShutdownFinishesInFlight(t *testing.T) {
+	b, msgCh := deliver(t, amqp091.Publishing{MessageId: "m1"}, amqp091.Publishing{MessageId: "m2"})
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var handled []string
+	w := &mqconsumer.PoolWorker{Num: 1, MaxInFlight: 1}
+	done := serve(w, ctx, func(ctx context.Context, msg amqp091.Delivery) interface{} {
+		handled = append(handled, msg.MessageId)
+		if msg.MessageId == "m1" {
+			close(started)
+			<-release
+		}
+		return msg.Ack(false)
+	}, msgCh)
+
+	<-started
+	// let the dispatcher take m2, which then waits for the in-flight slot of m1; the shutdown requeues it
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wait(t, done)
+
+	if len(handled) != 1 || handled[0] != "m1" {
+		t.Errorf("handled %v, want only the in-flight m1", handled)
+	}
+	msgs := b.Messages(workerQueue)
+	if len(msgs) != 1 || msgs[0].MessageId != "m2" || b.Unacked() != 0 {
+		t.Errorf("queue holds %v with %d unacked, want m2 requeued and m1 acked", msgs, b.Unacked())
+	}
+}
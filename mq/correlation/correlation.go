@@ -0,0 +1,36 @@
+package correlation
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Header carries the correlation id between services.
+const Header = "x-correlation-id"
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the correlation id of ctx, empty if none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// FromDelivery reads the correlation id header, falling back to the CorrelationId property.
+func FromDelivery(msg amqp091.Delivery) string {
+	if v, ok := msg.Headers[Header]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return msg.CorrelationId
+}
+
+// NewID generates a correlation id.
+func NewID() string {
+	return uuid.NewString()
+}
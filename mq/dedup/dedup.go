@@ -0,0 +1,216 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"github.com/latifrons/latigo/berror"
+	"github.com/latifrons/latigo/mq/consumer"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"sync"
+	"sync/atomic"
+)
+
+const ErrDuplicateInProgress = "ErrDuplicateInProgress"
+
+// KeyFunc extracts the deduplication key of a delivery. An empty key disables deduplication for the delivery.
+type KeyFunc func(msg amqp091.Delivery) string
+
+func KeyFromMessageId(msg amqp091.Delivery) string {
+	return msg.MessageId
+}
+
+type Stats struct {
+	Processed  uint64
+	Duplicates uint64
+}
+
+type Option func(*Deduplicator)
+
+// Deduplicator is a consumer middleware that skips messages whose key has already been processed.
+type Deduplicator struct {
+	Store   Store
+	KeyFunc KeyFunc
+
+	inProgress sync.Map
+	processed  atomic.Uint64
+	duplicates atomic.Uint64
+}
+
+func New(store Store, opts ...Option) *Deduplicator {
+	d := &Deduplicator{
+		Store:   store,
+		KeyFunc: KeyFromMessageId,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+func WithKeyFunc(keyFunc KeyFunc) Option {
+	return func(d *Deduplicator) {
+		d.KeyFunc = keyFunc
+	}
+}
+
+func (d *Deduplicator) Stats() Stats {
+	return Stats{
+		Processed:  d.processed.Load(),
+		Duplicates: d.duplicates.Load(),
+	}
+}
+
+type completionKey struct{}
+
+type completion struct {
+	key   string
+	store Store
+	mu    sync.Mutex
+	done  bool
+}
+
+func (c *completion) isDone() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done
+}
+
+// CompleteInTx records the message in the handler's own transaction, so the business change and the
+// deduplication record commit or roll back together. The handler calls committed once tx has committed; until then
+// the message counts as not recorded and the middleware records it with MarkDone when the handler succeeds. Requires
+// a TxStore; otherwise it only returns a no-op committed.
+//
+//	err = db.Transaction(func(tx *gorm.DB) error {
+//		committed, err = dedup.CompleteInTx(ctx, tx)
+//		...
+//	})
+//	if err == nil {
+//		committed()
+//	}
+func CompleteInTx(ctx context.Context, tx *gorm.DB) (committed func(), err error) {
+	c, ok := ctx.Value(completionKey{}).(*completion)
+	if !ok {
+		return func() {}, nil
+	}
+	txStore, ok := c.store.(TxStore)
+	if !ok {
+		return func() {}, nil
+	}
+	err = txStore.MarkDoneTx(tx, c.key)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		c.mu.Lock()
+		c.done = true
+		c.mu.Unlock()
+	}, nil
+}
+
+// begin returns the completion of a message to handle, nil when the message has no key, or seen for a duplicate.
+// A message whose key is being handled concurrently fails with a temporary error; the caller must call end for a
+// returned completion.
+func (d *Deduplicator) begin(ctx context.Context, msg amqp091.Delivery) (c *completion, seen bool, err error) {
+	key := d.KeyFunc(msg)
+	if key == "" {
+		return nil, false, nil
+	}
+	if _, loaded := d.inProgress.LoadOrStore(key, struct{}{}); loaded {
+		return nil, false, berror.NewBusinessTemporary(nil, ErrDuplicateInProgress, fmt.Sprintf("message %s is being processed", key))
+	}
+	seen, err = d.Store.Seen(ctx, key)
+	if err != nil {
+		d.inProgress.Delete(key)
+		return nil, false, berror.NewSystemTemporary(err, berror.ErrInternal, "failed to check processed messages")
+	}
+	if seen {
+		d.inProgress.Delete(key)
+		d.duplicates.Add(1)
+		log.Info().Str("key", key).Str("routingKey", msg.RoutingKey).Msg("duplicate message skipped")
+		return nil, true, nil
+	}
+	return &completion{key: key, store: d.Store}, false, nil
+}
+
+// complete records a successfully handled message unless CompleteInTx committed it already.
+func (d *Deduplicator) complete(ctx context.Context, c *completion) {
+	d.processed.Add(1)
+	if c.isDone() {
+		return
+	}
+	if err := d.Store.MarkDone(ctx, c.key); err != nil {
+		// the message is handled; failing now would only cause a redundant retry
+		log.Error().Err(err).Str("key", c.key).Msg("failed to record processed message")
+	}
+}
+
+func (d *Deduplicator) end(c *completion) {
+	d.inProgress.Delete(c.key)
+}
+
+// Wrap returns a handler that acks duplicates without calling next and records the key once next succeeds.
+// A message whose key is being handled concurrently fails with a temporary error so it is retried later.
+func (d *Deduplicator) Wrap(next consumer.ErrorHandleFunc) consumer.ErrorHandleFunc {
+	return func(ctx context.Context, msg amqp091.Delivery) error {
+		c, seen, err := d.begin(ctx, msg)
+		if err != nil || seen {
+			return err
+		}
+		if c == nil {
+			return next(ctx, msg)
+		}
+		defer d.end(c)
+
+		err = next(context.WithValue(ctx, completionKey{}, c), msg)
+		if err != nil {
+			return err
+		}
+		d.complete(ctx, c)
+		return nil
+	}
+}
+
+// Middleware deduplicates for handlers that ack themselves. Duplicates are acked without calling next, the key is
+// recorded when next acks the message, before the ack reaches the broker. A nacked or rejected message is not
+// recorded. A message whose key is being handled concurrently, or whose key cannot be checked, is nacked and
+// requeued.
+func (d *Deduplicator) Middleware() consumer.Middleware {
+	return func(next consumer.HandleFunc) consumer.HandleFunc {
+		return func(ctx context.Context, msg amqp091.Delivery) interface{} {
+			c, seen, err := d.begin(ctx, msg)
+			if err != nil {
+				log.Warn().Err(err).Str("messageId", msg.MessageId).Msg("cannot deduplicate message, requeueing")
+				return msg.Nack(false, true)
+			}
+			if seen {
+				return msg.Ack(false)
+			}
+			if c == nil || msg.Acknowledger == nil {
+				if c != nil {
+					d.end(c)
+				}
+				return next(ctx, msg)
+			}
+			defer d.end(c)
+
+			ctx = context.WithValue(ctx, completionKey{}, c)
+			msg.Acknowledger = &recordingAcknowledger{Acknowledger: msg.Acknowledger, ctx: ctx, d: d, c: c}
+			return next(ctx, msg)
+		}
+	}
+}
+
+// recordingAcknowledger records the completion when the handler acks the delivery.
+type recordingAcknowledger struct {
+	amqp091.Acknowledger
+	ctx context.Context
+	d   *Deduplicator
+	c   *completion
+}
+
+func (a *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.d.complete(a.ctx, a.c)
+	return a.Acknowledger.Ack(tag, multiple)
+}
@@ -0,0 +1,147 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"github.com/latifrons/latigo/mq/consumer"
+	"github.com/latifrons/latigo/mq/mqtest"
+	"github.com/latifrons/latigo/mq/topology"
+	"github.com/rabbitmq/amqp091-go"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+// txStore counts how completion is recorded.
+type txStore struct {
+	*MemoryStore
+	markDone   int
+	markDoneTx int
+}
+
+func (s *txStore) MarkDone(ctx context.Context, key string) error {
+	s.markDone++
+	return s.MemoryStore.MarkDone(ctx, key)
+}
+
+func (s *txStore) MarkDoneTx(tx *gorm.DB, key string) error {
+	s.markDoneTx++
+	return nil
+}
+
+func delivery(messageId string) amqp091.Delivery {
+	return amqp091.Delivery{MessageId: messageId}
+}
+
+func TestWrapSkipsDuplicates(t *testing.T) {
+	d := New(NewMemoryStore(10, time.Hour))
+	handled := 0
+	failure := errors.New("failed")
+	var result error
+	h := d.Wrap(func(ctx context.Context, msg amqp091.Delivery) error {
+		handled++
+		return result
+	})
+
+	result = failure
+	if err := h(context.Background(), delivery("m1")); !errors.Is(err, failure) {
+		t.Fatalf("failing handler: %v, want %v", err, failure)
+	}
+	result = nil
+	for i := 0; i < 2; i++ {
+		if err := h(context.Background(), delivery("m1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if handled != 2 {
+		t.Errorf("handled %d times, want the failure and one success", handled)
+	}
+	if stats := d.Stats(); stats.Processed != 1 || stats.Duplicates != 1 {
+		t.Errorf("stats %+v, want 1 processed and 1 duplicate", stats)
+	}
+}
+
+func TestCompleteInTxRecordsAfterCommit(t *testing.T) {
+	store := &txStore{MemoryStore: NewMemoryStore(10, time.Hour)}
+	d := New(store)
+	commit := false
+	h := d.Wrap(func(ctx context.Context, msg amqp091.Delivery) error {
+		committed, err := CompleteInTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if commit {
+			committed()
+		}
+		return nil
+	})
+
+	// rolled back: the middleware falls back to MarkDone
+	if err := h(context.Background(), delivery("m1")); err != nil {
+		t.Fatal(err)
+	}
+	if store.markDoneTx != 1 || store.markDone != 1 {
+		t.Errorf("MarkDoneTx %d and MarkDone %d times, want 1 and 1", store.markDoneTx, store.markDone)
+	}
+
+	commit = true
+	if err := h(context.Background(), delivery("m2")); err != nil {
+		t.Fatal(err)
+	}
+	if store.markDoneTx != 2 || store.markDone != 1 {
+		t.Errorf("MarkDoneTx %d and MarkDone %d times, want 2 and 1 after a commit", store.markDoneTx, store.markDone)
+	}
+
+	// without the middleware there is nothing to record
+	committed, err := CompleteInTx(context.Background(), nil)
+	if err != nil || committed == nil {
+		t.Fatalf("CompleteInTx outside the middleware: %v", err)
+	}
+	committed()
+}
+
+func TestMiddlewareOnBroker(t *testing.T) {
+	b := mqtest.NewBroker()
+	err := b.Declare(topology.Topology{
+		Exchanges: []topology.Exchange{{Name: "orders", Kind: amqp091.ExchangeDirect}},
+		Queues:    []topology.Queue{{Name: "orders.created"}},
+		Bindings:  []topology.Binding{{Queue: "orders.created", Exchange: "orders", RoutingKey: "created"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(NewMemoryStore(10, time.Hour))
+	handled := map[string]int{}
+	h := consumer.Chain(func(ctx context.Context, msg amqp091.Delivery) interface{} {
+		handled[msg.MessageId]++
+		if string(msg.Body) == "reject" {
+			return msg.Reject(false)
+		}
+		return msg.Ack(false)
+	}, d.Middleware())
+	c := b.NewConsumer("orders.created", h, 0)
+	if err = c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	p := b.NewPublisher(true)
+	for _, m := range []struct{ id, body string }{{"m1", "ok"}, {"m1", "ok"}, {"m2", "reject"}, {"m2", "ok"}} {
+		err = p.Publish(context.Background(), "orders", "created", amqp091.Publishing{MessageId: m.id, Body: []byte(m.body)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := b.Flush(); n != 4 {
+		t.Fatalf("delivered %d, want 4", n)
+	}
+	if handled["m1"] != 1 || handled["m2"] != 2 {
+		t.Errorf("handled %v, want m1 once and the rejected m2 again", handled)
+	}
+	if stats := d.Stats(); stats.Processed != 2 || stats.Duplicates != 1 {
+		t.Errorf("stats %+v, want 2 processed and 1 duplicate", stats)
+	}
+	if n := b.Unacked(); n != 0 {
+		t.Errorf("%d deliveries left unacked", n)
+	}
+}
@@ -0,0 +1,134 @@
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sync"
+	"time"
+)
+
+// Store remembers the keys of messages that have been processed.
+type Store interface {
+	Seen(ctx context.Context, key string) (bool, error)
+	MarkDone(ctx context.Context, key string) error
+}
+
+// TxStore is a Store able to record completion inside a caller's gorm transaction.
+type TxStore interface {
+	Store
+	MarkDoneTx(tx *gorm.DB, key string) error
+}
+
+// MemoryStore is an LRU of processed keys with a TTL. It only protects against redeliveries seen by this process.
+type MemoryStore struct {
+	capacity int
+	ttl      time.Duration
+	mu       sync.Mutex
+	items    map[string]*list.Element
+	order    *list.List
+}
+
+type memoryItem struct {
+	key    string
+	expire time.Time
+}
+
+func NewMemoryStore(capacity int, ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (s *MemoryStore) Seen(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[key]
+	if !ok {
+		return false, nil
+	}
+	if s.ttl > 0 && time.Now().After(e.Value.(*memoryItem).expire) {
+		s.order.Remove(e)
+		delete(s.items, key)
+		return false, nil
+	}
+	s.order.MoveToFront(e)
+	return true, nil
+}
+
+func (s *MemoryStore) MarkDone(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expire := time.Now().Add(s.ttl)
+	if e, ok := s.items[key]; ok {
+		e.Value.(*memoryItem).expire = expire
+		s.order.MoveToFront(e)
+		return nil
+	}
+	s.items[key] = s.order.PushFront(&memoryItem{key: key, expire: expire})
+	for s.capacity > 0 && s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.items, oldest.Value.(*memoryItem).key)
+	}
+	return nil
+}
+
+const DefaultTableName = "processed_messages"
+
+type ProcessedMessage struct {
+	MessageKey  string    `gorm:"primaryKey;size:255"`
+	ProcessedAt time.Time `gorm:"index"`
+}
+
+func (ProcessedMessage) TableName() string {
+	return DefaultTableName
+}
+
+// GormStore keeps processed keys in a database table.
+type GormStore struct {
+	DB        *gorm.DB
+	TableName string
+}
+
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{
+		DB:        db,
+		TableName: DefaultTableName,
+	}
+}
+
+func (s *GormStore) AutoMigrate() error {
+	return s.table(s.DB).AutoMigrate(&ProcessedMessage{})
+}
+
+func (s *GormStore) table(db *gorm.DB) *gorm.DB {
+	return db.Table(s.TableName)
+}
+
+func (s *GormStore) Seen(ctx context.Context, key string) (bool, error) {
+	var count int64
+	err := s.table(s.DB.WithContext(ctx)).Where("message_key = ?", key).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *GormStore) MarkDone(ctx context.Context, key string) error {
+	return s.MarkDoneTx(s.DB.WithContext(ctx), key)
+}
+
+func (s *GormStore) MarkDoneTx(tx *gorm.DB, key string) error {
+	return s.table(tx).Clauses(clause.OnConflict{DoNothing: true}).Create(&ProcessedMessage{
+		MessageKey:  key,
+		ProcessedAt: time.Now(),
+	}).Error
+}
+
+// Purge deletes keys processed before the given time.
+func (s *GormStore) Purge(before time.Time) (int64, error) {
+	result := s.table(s.DB).Where("processed_at < ?", before).Delete(&ProcessedMessage{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,586 @@
+// Package mqtest provides an in-process stand-in for RabbitMQ, for tests of code built on mq/consumer and
+// mq/publisher. Nothing happens in the background: published messages sit in their queues until Flush delivers
+// them, and message TTLs only expire when the broker clock is moved with Advance.
+package mqtest
+
+import (
+	"context"
+	"fmt"
+	"github.com/latifrons/latigo/mq/publisher"
+	"github.com/latifrons/latigo/mq/topology"
+	"github.com/rabbitmq/amqp091-go"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var _ topology.Declarer = (*Broker)(nil)
+
+type exchange struct {
+	name     string
+	kind     string
+	bindings []binding
+}
+
+// binding routes to a queue, or to an exchange for exchange-to-exchange bindings.
+type binding struct {
+	key         string
+	queue       string
+	destination string
+}
+
+type queue struct {
+	name       string
+	durable    bool
+	autoDelete bool
+	exclusive  bool
+	args       amqp091.Table
+	ready      []*message
+	// round robin position among the consumers of the queue
+	next int
+}
+
+type message struct {
+	exchange    string
+	key         string
+	publishing  amqp091.Publishing
+	enqueued    time.Time
+	redelivered bool
+}
+
+type unacked struct {
+	queue    *queue
+	msg      *message
+	consumer *Consumer
+}
+
+// Broker is an in-memory AMQP broker. The zero value is not usable, use NewBroker.
+type Broker struct {
+	mu         sync.Mutex
+	exchanges  map[string]*exchange
+	queues     map[string]*queue
+	queueOrder []string
+	consumers  []*Consumer
+	publishers []*Publisher
+	unacked    map[uint64]*unacked
+	connected  bool
+	epoch      uint64
+	nextTag    uint64
+	nextQueue  int
+	start      time.Time
+	offset     time.Duration
+}
+
+func NewBroker() *Broker {
+	b := &Broker{
+		exchanges: make(map[string]*exchange),
+		queues:    make(map[string]*queue),
+		unacked:   make(map[uint64]*unacked),
+		connected: true,
+		start:     time.Now(),
+	}
+	for _, e := range []struct{ name, kind string }{
+		{"amq.direct", amqp091.ExchangeDirect},
+		{"amq.topic", amqp091.ExchangeTopic},
+		{"amq.fanout", amqp091.ExchangeFanout},
+	} {
+		b.exchanges[e.name] = &exchange{name: e.name, kind: e.kind}
+	}
+	return b
+}
+
+// Now is the broker clock used for message expiry.
+func (b *Broker) Now() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.now()
+}
+
+func (b *Broker) now() time.Time {
+	return b.start.Add(b.offset)
+}
+
+// Advance moves the broker clock forward and expires messages whose TTL elapsed.
+func (b *Broker) Advance(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.offset += d
+	b.expire()
+}
+
+// Declare applies a topology, like publisher and consumer do on connect.
+func (b *Broker) Declare(t topology.Topology) error {
+	return t.Declare(b)
+}
+
+func (b *Broker) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp091.Table) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch kind {
+	case amqp091.ExchangeDirect, amqp091.ExchangeTopic, amqp091.ExchangeFanout:
+	default:
+		return fmt.Errorf("mqtest: unsupported exchange kind %q", kind)
+	}
+	if e, ok := b.exchanges[name]; ok {
+		if e.kind != kind {
+			return &amqp091.Error{Code: amqp091.PreconditionFailed, Reason: fmt.Sprintf("inequivalent arg 'type' for exchange '%s'", name)}
+		}
+		return nil
+	}
+	b.exchanges[name] = &exchange{name: name, kind: kind}
+	return nil
+}
+
+func (b *Broker) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp091.Table) (amqp091.Queue, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if name == "" {
+		b.nextQueue++
+		name = "amq.gen-" + strconv.Itoa(b.nextQueue)
+	}
+	q, ok := b.queues[name]
+	if !ok {
+		q = &queue{name: name, durable: durable, autoDelete: autoDelete, exclusive: exclusive, args: args}
+		b.queues[name] = q
+		b.queueOrder = append(b.queueOrder, name)
+	}
+	// like RabbitMQ, a redeclaration must match; arguments are not compared
+	for _, flag := range []struct {
+		name            string
+		existing, given bool
+	}{
+		{"durable", q.durable, durable},
+		{"exclusive", q.exclusive, exclusive},
+		{"auto_delete", q.autoDelete, autoDelete},
+	} {
+		if flag.existing != flag.given {
+			return amqp091.Queue{}, &amqp091.Error{Code: amqp091.PreconditionFailed, Reason: fmt.Sprintf("inequivalent arg '%s' for queue '%s'", flag.name, name)}
+		}
+	}
+	return amqp091.Queue{Name: name, Messages: len(q.ready), Consumers: b.consumerCount(name)}, nil
+}
+
+func (b *Broker) QueueBind(name, key, exchangeName string, noWait bool, args amqp091.Table) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.exchanges[exchangeName]
+	if !ok {
+		return notFound("exchange", exchangeName)
+	}
+	if _, ok := b.queues[name]; !ok {
+		return notFound("queue", name)
+	}
+	e.bindings = append(e.bindings, binding{key: key, queue: name})
+	return nil
+}
+
+func (b *Broker) ExchangeBind(destination, key, source string, noWait bool, args amqp091.Table) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.exchanges[source]
+	if !ok {
+		return notFound("exchange", source)
+	}
+	if _, ok := b.exchanges[destination]; !ok {
+		return notFound("exchange", destination)
+	}
+	e.bindings = append(e.bindings, binding{key: key, destination: destination})
+	return nil
+}
+
+func notFound(kind string, name string) error {
+	return &amqp091.Error{Code: amqp091.NotFound, Reason: fmt.Sprintf("no %s '%s'", kind, name)}
+}
+
+// PublishWithContext publishes like an AMQP channel, so the broker can stand in for consumer.ChannelPublisher.
+// An unroutable mandatory message returns a *publisher.ReturnedError.
+func (b *Broker) PublishWithContext(ctx context.Context, exchangeName, key string, mandatory, immediate bool, msg amqp091.Publishing) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.connected {
+		return amqp091.ErrClosed
+	}
+	if exchangeName != "" {
+		if _, ok := b.exchanges[exchangeName]; !ok {
+			return notFound("exchange", exchangeName)
+		}
+	}
+	queues := b.route(exchangeName, key)
+	if len(queues) == 0 && mandatory {
+		return &publisher.ReturnedError{
+			Exchange:   exchangeName,
+			RoutingKey: key,
+			ReplyCode:  amqp091.NoRoute,
+			ReplyText:  "NO_ROUTE",
+			MessageId:  msg.MessageId,
+		}
+	}
+	for _, q := range queues {
+		b.enqueue(q, exchangeName, key, msg)
+	}
+	return nil
+}
+
+// route returns the queues the message goes to, following exchange-to-exchange bindings.
+func (b *Broker) route(exchangeName, key string) []*queue {
+	if exchangeName == "" {
+		if q, ok := b.queues[key]; ok {
+			return []*queue{q}
+		}
+		return nil
+	}
+	var result []*queue
+	seenQueues := make(map[string]bool)
+	seenExchanges := make(map[string]bool)
+	var walk func(name string)
+	walk = func(name string) {
+		if seenExchanges[name] {
+			return
+		}
+		seenExchanges[name] = true
+		e, ok := b.exchanges[name]
+		if !ok {
+			return
+		}
+		for _, bd := range e.bindings {
+			if !matches(e.kind, bd.key, key) {
+				continue
+			}
+			if bd.destination != "" {
+				walk(bd.destination)
+			} else if !seenQueues[bd.queue] {
+				seenQueues[bd.queue] = true
+				result = append(result, b.queues[bd.queue])
+			}
+		}
+	}
+	walk(exchangeName)
+	return result
+}
+
+func matches(kind, bindingKey, routingKey string) bool {
+	switch kind {
+	case amqp091.ExchangeFanout:
+		return true
+	case amqp091.ExchangeTopic:
+		return topology.MatchTopic(bindingKey, routingKey)
+	default:
+		return bindingKey == routingKey
+	}
+}
+
+func (b *Broker) enqueue(q *queue, exchangeName, key string, p amqp091.Publishing) {
+	headers := amqp091.Table{}
+	for k, v := range p.Headers {
+		headers[k] = v
+	}
+	p.Headers = headers
+	p.Body = append([]byte(nil), p.Body...)
+	q.ready = append(q.ready, &message{
+		exchange:   exchangeName,
+		key:        key,
+		publishing: p,
+		enqueued:   b.now(),
+	})
+}
+
+// deadLetter routes the message to the dead letter exchange of q, if configured, otherwise drops it.
+func (b *Broker) deadLetter(q *queue, msg *message, reason string) {
+	dlx, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+	key := msg.key
+	if k, ok := q.args["x-dead-letter-routing-key"].(string); ok && k != "" {
+		key = k
+	}
+	p := msg.publishing
+	headers := amqp091.Table{}
+	for k, v := range p.Headers {
+		headers[k] = v
+	}
+	if _, ok := headers["x-first-death-reason"]; !ok {
+		headers["x-first-death-reason"] = reason
+		headers["x-first-death-queue"] = q.name
+		headers["x-first-death-exchange"] = msg.exchange
+	}
+	p.Headers = headers
+	// per-message TTL does not survive dead-lettering
+	p.Expiration = ""
+	for _, target := range b.route(dlx, key) {
+		b.enqueue(target, dlx, key, p)
+	}
+}
+
+func (b *Broker) expire() {
+	now := b.now()
+	for _, name := range b.queueOrder {
+		q := b.queues[name]
+		queueTTL, hasQueueTTL := toMillis(q.args["x-message-ttl"])
+		kept := q.ready[:0]
+		var expired []*message
+		for _, msg := range q.ready {
+			ttl, hasTTL := queueTTL, hasQueueTTL
+			if msg.publishing.Expiration != "" {
+				if v, err := strconv.ParseInt(msg.publishing.Expiration, 10, 64); err == nil && (!hasTTL || v < ttl) {
+					ttl, hasTTL = v, true
+				}
+			}
+			if hasTTL && !now.Before(msg.enqueued.Add(time.Duration(ttl)*time.Millisecond)) {
+				expired = append(expired, msg)
+			} else {
+				kept = append(kept, msg)
+			}
+		}
+		q.ready = kept
+		for _, msg := range expired {
+			b.deadLetter(q, msg, "expired")
+		}
+	}
+}
+
+func toMillis(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case int:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case int64:
+		return x, true
+	case float64:
+		return int64(x), true
+	}
+	return 0, false
+}
+
+// Drop simulates a lost connection: unacked messages are requeued as redelivered, publishing fails with
+// amqp091.ErrClosed and consumers become unready until Restore.
+func (b *Broker) Drop() {
+	b.mu.Lock()
+	b.connected = false
+	b.epoch++
+	b.requeueUnacked(func(u *unacked) bool { return true })
+	consumers := append([]*Consumer(nil), b.consumers...)
+	publishers := append([]*Publisher(nil), b.publishers...)
+	b.mu.Unlock()
+
+	for _, c := range consumers {
+		c.setUnready(amqp091.ErrClosed)
+	}
+	for _, p := range publishers {
+		p.setUnready(amqp091.ErrClosed)
+	}
+}
+
+// Restore reconnects after Drop.
+func (b *Broker) Restore() {
+	b.mu.Lock()
+	b.connected = true
+	consumers := append([]*Consumer(nil), b.consumers...)
+	publishers := append([]*Publisher(nil), b.publishers...)
+	b.mu.Unlock()
+
+	for _, c := range consumers {
+		c.setReady()
+	}
+	for _, p := range publishers {
+		p.setReady()
+	}
+}
+
+// requeueUnacked puts matching unacked messages back in front of their queues, in delivery order.
+func (b *Broker) requeueUnacked(match func(u *unacked) bool) {
+	var tags []uint64
+	for tag, u := range b.unacked {
+		if match(u) {
+			tags = append(tags, tag)
+		}
+	}
+	// prepending the newest first leaves the oldest at the head
+	sort.Slice(tags, func(i, j int) bool { return tags[i] > tags[j] })
+	for _, tag := range tags {
+		u := b.unacked[tag]
+		delete(b.unacked, tag)
+		u.msg.redelivered = true
+		u.queue.ready = append([]*message{u.msg}, u.queue.ready...)
+		u.consumer.inflight--
+	}
+}
+
+// Flush delivers ready messages to started consumers, synchronously and in queue order, until nothing more can
+// be delivered. Handlers run on the calling goroutine. It returns the number of deliveries.
+func (b *Broker) Flush() int {
+	total := 0
+	for b.deliverOne() {
+		total++
+	}
+	return total
+}
+
+func (b *Broker) deliverOne() bool {
+	b.mu.Lock()
+	b.expire()
+	if !b.connected {
+		b.mu.Unlock()
+		return false
+	}
+	for _, name := range b.queueOrder {
+		q := b.queues[name]
+		if len(q.ready) == 0 {
+			continue
+		}
+		consumers := b.queueConsumers(name)
+		for i := 0; i < len(consumers); i++ {
+			c := consumers[(q.next+i)%len(consumers)]
+			if c.prefetch > 0 && c.inflight >= c.prefetch {
+				continue
+			}
+			q.next = (q.next + i + 1) % len(consumers)
+			msg := q.ready[0]
+			q.ready = q.ready[1:]
+			b.nextTag++
+			tag := b.nextTag
+			b.unacked[tag] = &unacked{queue: q, msg: msg, consumer: c}
+			c.inflight++
+			delivery := b.delivery(q, msg, tag)
+			b.mu.Unlock()
+
+			c.handle(delivery)
+			return true
+		}
+	}
+	b.mu.Unlock()
+	return false
+}
+
+func (b *Broker) delivery(q *queue, msg *message, tag uint64) amqp091.Delivery {
+	p := msg.publishing
+	return amqp091.Delivery{
+		Acknowledger:    &acknowledger{broker: b, epoch: b.epoch},
+		Headers:         p.Headers,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageId,
+		Timestamp:       p.Timestamp,
+		Type:            p.Type,
+		UserId:          p.UserId,
+		AppId:           p.AppId,
+		DeliveryTag:     tag,
+		Redelivered:     msg.redelivered,
+		Exchange:        msg.exchange,
+		RoutingKey:      msg.key,
+		Body:            p.Body,
+	}
+}
+
+func (b *Broker) queueConsumers(name string) []*Consumer {
+	var result []*Consumer
+	for _, c := range b.consumers {
+		if c.queue == name && c.started {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func (b *Broker) consumerCount(name string) int {
+	return len(b.queueConsumers(name))
+}
+
+// acknowledger settles deliveries. Deliveries from before a Drop are rejected with amqp091.ErrClosed,
+// as their channel is gone.
+type acknowledger struct {
+	broker *Broker
+	epoch  uint64
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	return a.broker.settle(a.epoch, tag, multiple, func(u *unacked) {})
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	return a.broker.settle(a.epoch, tag, multiple, a.reject(requeue))
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	return a.broker.settle(a.epoch, tag, false, a.reject(requeue))
+}
+
+func (a *acknowledger) reject(requeue bool) func(u *unacked) {
+	return func(u *unacked) {
+		if requeue {
+			u.msg.redelivered = true
+			u.queue.ready = append([]*message{u.msg}, u.queue.ready...)
+			return
+		}
+		a.broker.deadLetter(u.queue, u.msg, "rejected")
+	}
+}
+
+func (b *Broker) settle(epoch uint64, tag uint64, multiple bool, action func(u *unacked)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if epoch != b.epoch || !b.connected {
+		return amqp091.ErrClosed
+	}
+	u, ok := b.unacked[tag]
+	if !ok {
+		return &amqp091.Error{Code: amqp091.PreconditionFailed, Reason: fmt.Sprintf("unknown delivery tag %d", tag)}
+	}
+	tags := []uint64{tag}
+	if multiple {
+		for t, other := range b.unacked {
+			if t < tag && other.consumer == u.consumer {
+				tags = append(tags, t)
+			}
+		}
+	}
+	// newest first, so requeued messages keep their order
+	sort.Slice(tags, func(i, j int) bool { return tags[i] > tags[j] })
+	for _, t := range tags {
+		settled := b.unacked[t]
+		delete(b.unacked, t)
+		settled.consumer.inflight--
+		action(settled)
+	}
+	return nil
+}
+
+// QueueLen returns the number of ready messages in the queue.
+func (b *Broker) QueueLen(name string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[name]
+	if !ok {
+		return 0
+	}
+	return len(q.ready)
+}
+
+// Unacked returns the number of delivered but not yet settled messages.
+func (b *Broker) Unacked() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.unacked)
+}
+
+// Messages returns a copy of the ready messages of the queue, oldest first.
+func (b *Broker) Messages(name string) []amqp091.Publishing {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[name]
+	if !ok {
+		return nil
+	}
+	result := make([]amqp091.Publishing, 0, len(q.ready))
+	for _, msg := range q.ready {
+		result = append(result, msg.publishing)
+	}
+	return result
+}
@@ -0,0 +1,330 @@
+package mqtest
+
+import (
+	"context"
+	"errors"
+	"github.com/latifrons/latigo/mq/consumer"
+	"github.com/latifrons/latigo/mq/topology"
+	"github.com/rabbitmq/amqp091-go"
+	"testing"
+	"time"
+)
+
+const (
+	testExchange = "orders"
+	testQueue    = "orders.created"
+	testKey      = "created"
+)
+
+func newTestBroker(t *testing.T, extra topology.Topology) *Broker {
+	t.Helper()
+	b := NewBroker()
+	base := topology.Topology{
+		Exchanges: []topology.Exchange{{Name: testExchange, Kind: amqp091.ExchangeDirect}},
+		Bindings:  []topology.Binding{{Queue: testQueue, Exchange: testExchange, RoutingKey: testKey}},
+	}
+	if len(extra.Queues) == 0 || extra.Queues[0].Name != testQueue {
+		base.Queues = []topology.Queue{{Name: testQueue}}
+	}
+	if err := b.Declare(base.Merge(extra)); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func publish(t *testing.T, b *Broker, messageId string) {
+	t.Helper()
+	err := b.NewPublisher(true).Publish(context.Background(), testExchange, testKey, amqp091.Publishing{
+		MessageId: messageId,
+		Body:      []byte(messageId),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetryRoundTrip(t *testing.T) {
+	policy := consumer.RetryPolicy{
+		Delays:             []time.Duration{time.Second, 5 * time.Second},
+		DeadLetterExchange: "orders.dlx",
+	}
+	b := newTestBroker(t, policy.Topology(testQueue))
+
+	var attempts []int
+	handler := func(ctx context.Context, msg amqp091.Delivery) error {
+		attempts = append(attempts, consumer.RetryAttempt(msg))
+		return errors.New("downstream unavailable")
+	}
+	c := b.NewConsumer(testQueue, consumer.NewRetryHandleFunc(handler, policy, testQueue, b.NewPublisher(true)), 0)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	publish(t, b, "m1")
+	if n := b.Flush(); n != 1 {
+		t.Fatalf("first flush delivered %d, want 1", n)
+	}
+	if n := b.QueueLen(testQueue + ".retry.1"); n != 1 {
+		t.Fatalf("first retry queue holds %d, want 1", n)
+	}
+
+	// the message waits in the delay queue until its TTL expires
+	b.Advance(999 * time.Millisecond)
+	if n := b.Flush(); n != 0 {
+		t.Fatalf("delivered %d before the retry delay, want 0", n)
+	}
+	b.Advance(time.Millisecond)
+	if n := b.QueueLen(testQueue + ".retry.1"); n != 0 {
+		t.Fatalf("first retry queue holds %d after the delay, want 0", n)
+	}
+	if n := b.Flush(); n != 1 {
+		t.Fatalf("delivered %d after the first delay, want 1", n)
+	}
+	if n := b.QueueLen(testQueue + ".retry.2"); n != 1 {
+		t.Fatalf("second retry queue holds %d, want 1", n)
+	}
+
+	b.Advance(5 * time.Second)
+	if n := b.Flush(); n != 1 {
+		t.Fatalf("delivered %d after the second delay, want 1", n)
+	}
+
+	if len(attempts) != 3 || attempts[0] != 0 || attempts[1] != 1 || attempts[2] != 2 {
+		t.Fatalf("attempts = %v, want [0 1 2]", attempts)
+	}
+	dead := b.Messages(testQueue + ".dead")
+	if len(dead) != 1 {
+		t.Fatalf("dead letter queue holds %d, want 1", len(dead))
+	}
+	if dead[0].MessageId != "m1" {
+		t.Errorf("dead letter message id %q, want m1", dead[0].MessageId)
+	}
+	if v := dead[0].Headers[consumer.HeaderRetryAttempt]; v != int32(2) {
+		t.Errorf("dead letter retry attempt %v, want 2", v)
+	}
+	if v := dead[0].Headers[consumer.HeaderOriginalRoutingKey]; v != testKey {
+		t.Errorf("dead letter original routing key %v, want %s", v, testKey)
+	}
+	if v := dead[0].Headers[consumer.HeaderLastError]; v != "downstream unavailable" {
+		t.Errorf("dead letter last error %v", v)
+	}
+	if n := b.Unacked(); n != 0 {
+		t.Errorf("%d deliveries left unacked", n)
+	}
+}
+
+func TestRetryRequeuedWhenNotConfirmed(t *testing.T) {
+	policy := consumer.RetryPolicy{Delays: []time.Duration{time.Second}}
+	// the delay queue is not declared, so the retry is unroutable
+	b := newTestBroker(t, topology.Topology{})
+
+	handled := 0
+	handler := func(ctx context.Context, msg amqp091.Delivery) error {
+		handled++
+		return errors.New("downstream unavailable")
+	}
+	c := b.NewConsumer(testQueue, consumer.NewRetryHandleFunc(handler, policy, testQueue, b.NewPublisher(true)), 1)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	publish(t, b, "m1")
+	if !b.deliverOne() {
+		t.Fatal("nothing delivered")
+	}
+	if handled != 1 {
+		t.Fatalf("handled %d times, want 1", handled)
+	}
+	if n := b.QueueLen(testQueue); n != 1 {
+		t.Errorf("queue holds %d after a failed retry, want the message requeued", n)
+	}
+	if n := b.Unacked(); n != 0 {
+		t.Errorf("%d deliveries left unacked", n)
+	}
+}
+
+func TestNackRequeueThenDeadLetter(t *testing.T) {
+	b := newTestBroker(t, topology.Topology{
+		Exchanges: []topology.Exchange{{Name: "dlx", Kind: amqp091.ExchangeFanout}},
+		Queues: []topology.Queue{
+			{Name: testQueue, DeadLetterExchange: "dlx"},
+			{Name: "dead"},
+		},
+		Bindings: []topology.Binding{{Queue: "dead", Exchange: "dlx"}},
+	})
+
+	var redelivered []bool
+	c := b.NewConsumer(testQueue, func(ctx context.Context, msg amqp091.Delivery) interface{} {
+		redelivered = append(redelivered, msg.Redelivered)
+		// requeue the first delivery, reject the redelivery
+		return msg.Nack(false, !msg.Redelivered)
+	}, 0)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	publish(t, b, "m1")
+	if n := b.Flush(); n != 2 {
+		t.Fatalf("delivered %d, want 2", n)
+	}
+	if len(redelivered) != 2 || redelivered[0] || !redelivered[1] {
+		t.Fatalf("redelivered flags %v, want [false true]", redelivered)
+	}
+	dead := b.Messages("dead")
+	if len(dead) != 1 {
+		t.Fatalf("dead letter queue holds %d, want 1", len(dead))
+	}
+	if v := dead[0].Headers["x-first-death-reason"]; v != "rejected" {
+		t.Errorf("death reason %v, want rejected", v)
+	}
+	if v := dead[0].Headers["x-first-death-queue"]; v != testQueue {
+		t.Errorf("death queue %v, want %s", v, testQueue)
+	}
+}
+
+func TestDropRequeuesUnacked(t *testing.T) {
+	b := newTestBroker(t, topology.Topology{})
+
+	var held []amqp091.Delivery
+	c := b.NewConsumer(testQueue, func(ctx context.Context, msg amqp091.Delivery) interface{} {
+		held = append(held, msg)
+		return nil
+	}, 0)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	p := b.NewPublisher(true)
+
+	publish(t, b, "m1")
+	publish(t, b, "m2")
+	if n := b.Flush(); n != 2 {
+		t.Fatalf("delivered %d, want 2", n)
+	}
+	if n := b.Unacked(); n != 2 {
+		t.Fatalf("unacked %d, want 2", n)
+	}
+
+	b.Drop()
+	if c.State().Ready() || p.State().Ready() {
+		t.Fatal("consumer or publisher ready after Drop")
+	}
+	if n := b.Unacked(); n != 0 {
+		t.Fatalf("unacked %d after Drop, want 0", n)
+	}
+	if n := b.QueueLen(testQueue); n != 2 {
+		t.Fatalf("queue holds %d after Drop, want 2 requeued", n)
+	}
+	if err := held[0].Ack(false); !errors.Is(err, amqp091.ErrClosed) {
+		t.Errorf("ack of a delivery from the dropped channel: %v, want ErrClosed", err)
+	}
+	if err := p.Publish(context.Background(), testExchange, testKey, amqp091.Publishing{}); !errors.Is(err, amqp091.ErrClosed) {
+		t.Errorf("publish while dropped: %v, want ErrClosed", err)
+	}
+	if n := b.Flush(); n != 0 {
+		t.Fatalf("delivered %d while dropped, want 0", n)
+	}
+
+	b.Restore()
+	if !c.State().Ready() || !p.State().Ready() {
+		t.Fatal("consumer or publisher not ready after Restore")
+	}
+	held = nil
+	if n := b.Flush(); n != 2 {
+		t.Fatalf("delivered %d after Restore, want 2", n)
+	}
+	for i, msg := range held {
+		if !msg.Redelivered {
+			t.Errorf("delivery %d after Restore not marked redelivered", i)
+		}
+		if want := []string{"m1", "m2"}[i]; msg.MessageId != want {
+			t.Errorf("delivery %d is %s, want %s", i, msg.MessageId, want)
+		}
+		if err := msg.Ack(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := b.Unacked(); n != 0 {
+		t.Errorf("unacked %d after acking the redeliveries, want 0", n)
+	}
+}
+
+func TestMultipleNackRequeuesInOrder(t *testing.T) {
+	b := newTestBroker(t, topology.Topology{})
+
+	var held []amqp091.Delivery
+	c := b.NewConsumer(testQueue, func(ctx context.Context, msg amqp091.Delivery) interface{} {
+		held = append(held, msg)
+		return nil
+	}, 0)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	for _, id := range []string{"m1", "m2", "m3", "m4"} {
+		publish(t, b, id)
+	}
+	if n := b.Flush(); n != 4 {
+		t.Fatalf("delivered %d, want 4", n)
+	}
+	// settle m1 first, so the multiple nack covers m2 to m4 only
+	if err := held[0].Ack(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := held[3].Nack(true, true); err != nil {
+		t.Fatal(err)
+	}
+	if n := b.Unacked(); n != 0 {
+		t.Fatalf("unacked %d after the multiple nack, want 0", n)
+	}
+	var ids []string
+	for _, p := range b.Messages(testQueue) {
+		ids = append(ids, p.MessageId)
+	}
+	if len(ids) != 3 || ids[0] != "m2" || ids[1] != "m3" || ids[2] != "m4" {
+		t.Fatalf("requeued %v, want [m2 m3 m4] in delivery order", ids)
+	}
+
+	held = nil
+	if n := b.Flush(); n != 3 {
+		t.Fatalf("redelivered %d, want 3", n)
+	}
+	for i, msg := range held {
+		if !msg.Redelivered || msg.MessageId != ids[i] {
+			t.Errorf("redelivery %d is %s redelivered %t, want %s redelivered", i, msg.MessageId, msg.Redelivered, ids[i])
+		}
+	}
+}
+
+func TestQueueRedeclareMustMatch(t *testing.T) {
+	b := NewBroker()
+	if _, err := b.QueueDeclare("q", true, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.QueueDeclare("q", true, false, false, false, nil); err != nil {
+		t.Errorf("equivalent redeclare: %v", err)
+	}
+	for name, declare := range map[string]func() error{
+		"durable": func() error {
+			_, err := b.QueueDeclare("q", false, false, false, false, nil)
+			return err
+		},
+		"exclusive": func() error {
+			_, err := b.QueueDeclare("q", true, false, true, false, nil)
+			return err
+		},
+		"auto_delete": func() error {
+			_, err := b.QueueDeclare("q", true, true, false, false, nil)
+			return err
+		},
+	} {
+		var amqpErr *amqp091.Error
+		if err := declare(); !errors.As(err, &amqpErr) || amqpErr.Code != amqp091.PreconditionFailed {
+			t.Errorf("redeclare with another %s: %v, want PRECONDITION_FAILED", name, err)
+		}
+	}
+}
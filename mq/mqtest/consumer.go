@@ -0,0 +1,104 @@
+package mqtest
+
+import (
+	"context"
+	"fmt"
+	"github.com/latifrons/latigo/mq/connstate"
+	"github.com/latifrons/latigo/mq/consumer"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var _ consumer.MessageConsumer = (*Consumer)(nil)
+
+// Consumer consumes a broker queue with a consumer.HandleFunc, so handlers and middleware chains written for
+// consumer.ReliableRabbitConsumer run unchanged. Deliveries are made by Broker.Flush.
+type Consumer struct {
+	broker   *Broker
+	queue    string
+	handle   func(amqp091.Delivery)
+	prefetch int
+	tracker  *connstate.Tracker
+	started  bool
+	inflight int
+}
+
+// NewConsumer creates a consumer of queue. prefetch limits unacked deliveries, 0 means unlimited.
+func (b *Broker) NewConsumer(queue string, handleFunc consumer.HandleFunc, prefetch int) *Consumer {
+	c := &Consumer{
+		broker:   b,
+		queue:    queue,
+		prefetch: prefetch,
+		tracker:  connstate.NewTracker(),
+	}
+	c.handle = func(msg amqp091.Delivery) {
+		handleFunc(context.Background(), msg)
+	}
+	return c
+}
+
+// Start registers the consumer. The queue must have been declared.
+func (c *Consumer) Start() error {
+	b := c.broker
+	b.mu.Lock()
+	if _, ok := b.queues[c.queue]; !ok {
+		b.mu.Unlock()
+		return fmt.Errorf("mqtest: %w", notFound("queue", c.queue))
+	}
+	if !c.started {
+		c.started = true
+		b.consumers = append(b.consumers, c)
+	}
+	connected := b.connected
+	b.mu.Unlock()
+
+	if connected {
+		c.setReady()
+	} else {
+		c.setUnready(amqp091.ErrClosed)
+	}
+	return nil
+}
+
+// Stop unregisters the consumer and requeues its unacked deliveries.
+func (c *Consumer) Stop() {
+	b := c.broker
+	b.mu.Lock()
+	c.started = false
+	for i, other := range b.consumers {
+		if other == c {
+			b.consumers = append(b.consumers[:i], b.consumers[i+1:]...)
+			break
+		}
+	}
+	b.requeueUnacked(func(u *unacked) bool { return u.consumer == c })
+	b.mu.Unlock()
+	c.tracker.Close()
+}
+
+func (c *Consumer) Name() string {
+	return "MQTestConsumer(" + c.queue + ")"
+}
+
+func (c *Consumer) State() connstate.State {
+	return c.tracker.State()
+}
+
+func (c *Consumer) Subscribe() (<-chan connstate.State, func()) {
+	return c.tracker.Subscribe()
+}
+
+func (c *Consumer) WaitReady(ctx context.Context) error {
+	return c.tracker.WaitReady(ctx)
+}
+
+func (c *Consumer) Healthy() error {
+	return c.tracker.Healthy()
+}
+
+func (c *Consumer) setReady() {
+	c.tracker.Set(connstate.StatusReady, nil)
+}
+
+func (c *Consumer) setUnready(err error) {
+	c.tracker.Set(connstate.StatusUnready, err)
+}
@@ -0,0 +1,89 @@
+package mqtest
+
+import (
+	"context"
+	"github.com/latifrons/latigo/mq/connstate"
+	"github.com/latifrons/latigo/mq/publisher"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var _ publisher.MessagePublisher = (*Publisher)(nil)
+
+// Publisher publishes to the broker. It behaves like a publisher.ReliableRabbitPublisher in confirm mode:
+// Publish returns once the message is queued, amqp091.ErrClosed while the broker is dropped, and a
+// *publisher.ReturnedError for an unroutable message when mandatory is set.
+type Publisher struct {
+	broker    *Broker
+	mandatory bool
+	tracker   *connstate.Tracker
+}
+
+func (b *Broker) NewPublisher(mandatory bool) *Publisher {
+	p := &Publisher{
+		broker:    b,
+		mandatory: mandatory,
+		tracker:   connstate.NewTracker(),
+	}
+	b.mu.Lock()
+	b.publishers = append(b.publishers, p)
+	connected := b.connected
+	b.mu.Unlock()
+	if connected {
+		p.setReady()
+	}
+	return p
+}
+
+func (p *Publisher) Publish(ctx context.Context, exchange, key string, msg amqp091.Publishing) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.broker.PublishWithContext(ctx, exchange, key, p.mandatory, false, msg)
+}
+
+func (p *Publisher) PublishAsync(ctx context.Context, exchange, key string, msg amqp091.Publishing) *publisher.PublishFuture {
+	return publisher.CompletedFuture(p.Publish(ctx, exchange, key, msg))
+}
+
+func (p *Publisher) Start() {
+}
+
+func (p *Publisher) Stop() {
+	b := p.broker
+	b.mu.Lock()
+	for i, other := range b.publishers {
+		if other == p {
+			b.publishers = append(b.publishers[:i], b.publishers[i+1:]...)
+			break
+		}
+	}
+	b.mu.Unlock()
+	p.tracker.Close()
+}
+
+func (p *Publisher) Name() string {
+	return "MQTestPublisher"
+}
+
+func (p *Publisher) State() connstate.State {
+	return p.tracker.State()
+}
+
+func (p *Publisher) WaitReady(ctx context.Context) error {
+	return p.tracker.WaitReady(ctx)
+}
+
+func (p *Publisher) Healthy() error {
+	return p.tracker.Healthy()
+}
+
+func (p *Publisher) setReady() {
+	p.tracker.Set(connstate.StatusReady, nil)
+}
+
+func (p *Publisher) setUnready(err error) {
+	p.tracker.Set(connstate.StatusUnready, err)
+}
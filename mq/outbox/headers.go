@@ -0,0 +1,249 @@
+package outbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/rabbitmq/amqp091-go"
+	"time"
+)
+
+// headersPrefix marks headers stored with their types. Rows without it hold plain JSON written by older versions.
+var headersPrefix = []byte("typed1:")
+
+// typedValue is a header value with its AMQP field type, so it comes back as the same Go type.
+type typedValue struct {
+	T string          `json:"t"`
+	V json.RawMessage `json:"v,omitempty"`
+}
+
+type typedDecimal struct {
+	Scale uint8 `json:"scale"`
+	Value int32 `json:"value"`
+}
+
+// encodeHeaders stores headers losslessly. Types amqp091 cannot send are rejected.
+func encodeHeaders(headers amqp091.Table) ([]byte, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	err := headers.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("outbox headers: %w", err)
+	}
+	fields, err := encodeTable(headers)
+	if err != nil {
+		return nil, err
+	}
+	content, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, headersPrefix...), content...), nil
+}
+
+func encodeTable(t amqp091.Table) (map[string]typedValue, error) {
+	result := make(map[string]typedValue, len(t))
+	for k, v := range t {
+		tv, err := encodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("outbox header %q: %w", k, err)
+		}
+		result[k] = tv
+	}
+	return result, nil
+}
+
+func encodeValue(v interface{}) (tv typedValue, err error) {
+	var value interface{}
+	switch fv := v.(type) {
+	case nil:
+		return typedValue{T: "nil"}, nil
+	case bool:
+		tv.T, value = "bool", fv
+	case byte:
+		tv.T, value = "byte", fv
+	case int8:
+		tv.T, value = "int8", fv
+	case int16:
+		tv.T, value = "int16", fv
+	case int32:
+		tv.T, value = "int32", fv
+	case int:
+		tv.T, value = "int", fv
+	case int64:
+		tv.T, value = "int64", fv
+	case float32:
+		tv.T, value = "float32", fv
+	case float64:
+		tv.T, value = "float64", fv
+	case string:
+		tv.T, value = "string", fv
+	case []byte:
+		tv.T, value = "bytes", fv
+	case amqp091.Decimal:
+		tv.T, value = "decimal", typedDecimal{Scale: fv.Scale, Value: fv.Value}
+	case time.Time:
+		tv.T, value = "time", fv.Format(time.RFC3339Nano)
+	case []interface{}:
+		items := make([]typedValue, len(fv))
+		for i, item := range fv {
+			items[i], err = encodeValue(item)
+			if err != nil {
+				return
+			}
+		}
+		tv.T, value = "array", items
+	case amqp091.Table:
+		var fields map[string]typedValue
+		fields, err = encodeTable(fv)
+		if err != nil {
+			return
+		}
+		tv.T, value = "table", fields
+	default:
+		return tv, fmt.Errorf("value %T not supported", v)
+	}
+	tv.V, err = json.Marshal(value)
+	return
+}
+
+// decodeHeaders reads headers written by encodeHeaders, or plain JSON of older rows.
+func decodeHeaders(content []byte) (amqp091.Table, error) {
+	if len(content) == 0 {
+		return nil, nil
+	}
+	if !bytes.HasPrefix(content, headersPrefix) {
+		var headers map[string]interface{}
+		err := json.Unmarshal(content, &headers)
+		if err != nil {
+			return nil, err
+		}
+		return legacyTable(headers), nil
+	}
+	var fields map[string]typedValue
+	err := json.Unmarshal(content[len(headersPrefix):], &fields)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTable(fields)
+}
+
+func decodeTable(fields map[string]typedValue) (amqp091.Table, error) {
+	result := make(amqp091.Table, len(fields))
+	for k, tv := range fields {
+		v, err := decodeValue(tv)
+		if err != nil {
+			return nil, fmt.Errorf("outbox header %q: %w", k, err)
+		}
+		result[k] = v
+	}
+	return result, nil
+}
+
+func decodeValue(tv typedValue) (interface{}, error) {
+	var err error
+	switch tv.T {
+	case "nil":
+		return nil, nil
+	case "bool":
+		var v bool
+		err = json.Unmarshal(tv.V, &v)
+		return v, err
+	case "byte":
+		var v byte
+		err = json.Unmarshal(tv.V, &v)
+		return v, err
+	case "int8":
+		var v int8
+		err = json.Unmarshal(tv.V, &v)
+		return v, err
+	case "int16":
+		var v int16
+		err = json.Unmarshal(tv.V, &v)
+		return v, err
+	case "int32":
+		var v int32
+		err = json.Unmarshal(tv.V, &v)
+		return v, err
+	case "int":
+		var v int
+		err = json.Unmarshal(tv.V, &v)
+		return v, err
+	case "int64":
+		var v int64
+		err = json.Unmarshal(tv.V, &v)
+		return v, err
+	case "float32":
+		var v float32
+		err = json.Unmarshal(tv.V, &v)
+		return v, err
+	case "float64":
+		var v float64
+		err = json.Unmarshal(tv.V, &v)
+		return v, err
+	case "string":
+		var v string
+		err = json.Unmarshal(tv.V, &v)
+		return v, err
+	case "bytes":
+		var v []byte
+		err = json.Unmarshal(tv.V, &v)
+		return v, err
+	case "decimal":
+		var v typedDecimal
+		err = json.Unmarshal(tv.V, &v)
+		return amqp091.Decimal{Scale: v.Scale, Value: v.Value}, err
+	case "time":
+		var s string
+		err = json.Unmarshal(tv.V, &s)
+		if err != nil {
+			return nil, err
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case "array":
+		var items []typedValue
+		err = json.Unmarshal(tv.V, &items)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]interface{}, len(items))
+		for i, item := range items {
+			result[i], err = decodeValue(item)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case "table":
+		var fields map[string]typedValue
+		err = json.Unmarshal(tv.V, &fields)
+		if err != nil {
+			return nil, err
+		}
+		return decodeTable(fields)
+	}
+	return nil, fmt.Errorf("unknown header type %q", tv.T)
+}
+
+// legacyTable turns the nested maps of plain JSON into tables amqp091 accepts. Other types stay as JSON made them.
+func legacyTable(m map[string]interface{}) amqp091.Table {
+	result := make(amqp091.Table, len(m))
+	for k, v := range m {
+		result[k] = legacyValue(v)
+	}
+	return result
+}
+
+func legacyValue(v interface{}) interface{} {
+	switch fv := v.(type) {
+	case map[string]interface{}:
+		return legacyTable(fv)
+	case []interface{}:
+		for i, item := range fv {
+			fv[i] = legacyValue(item)
+		}
+		return fv
+	}
+	return v
+}
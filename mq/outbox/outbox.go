@@ -0,0 +1,147 @@
+package outbox
+
+import (
+	"github.com/rabbitmq/amqp091-go"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	StatusPending = 0
+	StatusSent    = 1
+	StatusFailed  = 2
+)
+
+const DefaultTableName = "outbox_messages"
+
+// OutboxMessage is a row of the outbox table. Rows are written by Enqueue inside the caller's transaction and
+// drained by the Relay.
+type OutboxMessage struct {
+	ID              uint64 `gorm:"primaryKey;autoIncrement"`
+	OrderKey        string `gorm:"size:255;index"`
+	Exchange        string `gorm:"size:255"`
+	RoutingKey      string `gorm:"size:255"`
+	ContentType     string `gorm:"size:255"`
+	ContentEncoding string `gorm:"size:255"`
+	DeliveryMode    uint8
+	Priority        uint8
+	CorrelationId   string `gorm:"size:255"`
+	ReplyTo         string `gorm:"size:255"`
+	Expiration      string `gorm:"size:64"`
+	MessageId       string `gorm:"size:255"`
+	Type            string `gorm:"size:255"`
+	AppId           string `gorm:"size:255"`
+	Timestamp       time.Time
+	Headers         []byte
+	Body            []byte
+	Status          int `gorm:"index"`
+	Attempts        int
+	NextAttemptAt   time.Time
+	LastError       string `gorm:"size:1024"`
+	CreatedAt       time.Time
+	SentAt          *time.Time `gorm:"index"`
+}
+
+func (OutboxMessage) TableName() string {
+	return DefaultTableName
+}
+
+// Message is what the caller puts into the outbox.
+// Messages sharing a non-empty OrderKey are published in enqueue order; a failing message holds back the
+// ones behind it until it is sent.
+type Message struct {
+	Exchange   string
+	Key        string
+	OrderKey   string
+	Publishing amqp091.Publishing
+}
+
+// AutoMigrate creates or updates the outbox table.
+func AutoMigrate(db *gorm.DB, tableName string) error {
+	return table(db, tableName).AutoMigrate(&OutboxMessage{})
+}
+
+// Enqueue writes the messages into the outbox using tx. Call it with the transaction that carries the business
+// change so that both commit or roll back together.
+func Enqueue(tx *gorm.DB, msgs ...Message) error {
+	return EnqueueTable(tx, DefaultTableName, msgs...)
+}
+
+// EnqueueTable is Enqueue for an outbox table with a custom name. Messages with header values amqp091 cannot send
+// are rejected.
+func EnqueueTable(tx *gorm.DB, tableName string, msgs ...Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	rows := make([]OutboxMessage, 0, len(msgs))
+	now := time.Now()
+	for _, msg := range msgs {
+		row, err := toRow(msg, now)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+	return table(tx, tableName).Create(&rows).Error
+}
+
+func table(db *gorm.DB, tableName string) *gorm.DB {
+	if tableName == "" || tableName == DefaultTableName {
+		return db
+	}
+	return db.Table(tableName)
+}
+
+func toRow(msg Message, now time.Time) (row OutboxMessage, err error) {
+	p := msg.Publishing
+	headers, err := encodeHeaders(p.Headers)
+	if err != nil {
+		return
+	}
+	row = OutboxMessage{
+		OrderKey:        msg.OrderKey,
+		Exchange:        msg.Exchange,
+		RoutingKey:      msg.Key,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageId,
+		Type:            p.Type,
+		AppId:           p.AppId,
+		Timestamp:       p.Timestamp,
+		Headers:         headers,
+		Body:            p.Body,
+		Status:          StatusPending,
+		NextAttemptAt:   now,
+		CreatedAt:       now,
+	}
+	return
+}
+
+// Publishing rebuilds the amqp message of the row. Header values keep their types.
+func (m *OutboxMessage) Publishing() (p amqp091.Publishing, err error) {
+	headers, err := decodeHeaders(m.Headers)
+	if err != nil {
+		return
+	}
+	p = amqp091.Publishing{
+		Headers:         headers,
+		ContentType:     m.ContentType,
+		ContentEncoding: m.ContentEncoding,
+		DeliveryMode:    m.DeliveryMode,
+		Priority:        m.Priority,
+		CorrelationId:   m.CorrelationId,
+		ReplyTo:         m.ReplyTo,
+		Expiration:      m.Expiration,
+		MessageId:       m.MessageId,
+		Timestamp:       m.Timestamp,
+		Type:            m.Type,
+		AppId:           m.AppId,
+		Body:            m.Body,
+	}
+	return
+}
@@ -0,0 +1,271 @@
+package outbox
+
+import (
+	"context"
+	"github.com/latifrons/latigo/mq/codec"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+// Publisher is the part of publisher.ReliableRabbitPublisher the relay needs. The relay marks a message sent once
+// Publish returns nil, so use a publisher in confirm mode, with mandatory for messages that must be routed. Without
+// confirms "sent" only means the message left the process, not that the broker accepted it.
+type Publisher interface {
+	Publish(ctx context.Context, exchange, key string, msg amqp091.Publishing) error
+}
+
+type RelayOption func(*Relay)
+
+// Relay drains the outbox table through a Publisher.
+// Only one relay should drain a given table at a time, otherwise ordering per key is not guaranteed.
+type Relay struct {
+	DB        *gorm.DB
+	Publisher Publisher
+
+	tableName      string
+	batchSize      int
+	pollInterval   time.Duration
+	publishTimeout time.Duration
+	minBackoff     time.Duration
+	maxBackoff     time.Duration
+	maxAttempts    int
+	retention      time.Duration
+	purgeInterval  time.Duration
+
+	wakeCh chan struct{}
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewRelay(db *gorm.DB, publisher Publisher, opts ...RelayOption) *Relay {
+	r := &Relay{
+		DB:             db,
+		Publisher:      publisher,
+		tableName:      DefaultTableName,
+		batchSize:      100,
+		pollInterval:   time.Second,
+		publishTimeout: 10 * time.Second,
+		minBackoff:     time.Second,
+		maxBackoff:     5 * time.Minute,
+		retention:      7 * 24 * time.Hour,
+		purgeInterval:  time.Hour,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func WithTableName(tableName string) RelayOption {
+	return func(r *Relay) {
+		r.tableName = tableName
+	}
+}
+
+func WithBatchSize(batchSize int) RelayOption {
+	return func(r *Relay) {
+		r.batchSize = batchSize
+	}
+}
+
+func WithPollInterval(interval time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.pollInterval = interval
+	}
+}
+
+func WithPublishTimeout(timeout time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.publishTimeout = timeout
+	}
+}
+
+// WithBackoff sets the retry delay range. The delay doubles on every failed attempt.
+func WithBackoff(min time.Duration, max time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.minBackoff = min
+		r.maxBackoff = max
+	}
+}
+
+// WithMaxAttempts marks a message failed after n unsuccessful attempts. 0 retries forever.
+// A failed message no longer holds back the messages behind it.
+func WithMaxAttempts(n int) RelayOption {
+	return func(r *Relay) {
+		r.maxAttempts = n
+	}
+}
+
+// WithRetention sets how long sent messages are kept before being purged. 0 disables purging.
+func WithRetention(retention time.Duration, purgeInterval time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.retention = retention
+		r.purgeInterval = purgeInterval
+	}
+}
+
+func (r *Relay) Start() {
+	r.wakeCh = make(chan struct{}, 1)
+	r.quit = make(chan struct{})
+	r.wg.Add(1)
+	go r.loop()
+}
+
+func (r *Relay) Stop() {
+	close(r.quit)
+	r.wg.Wait()
+}
+
+func (r *Relay) Name() string {
+	return "OutboxRelay"
+}
+
+// Wake asks the relay to drain immediately instead of waiting for the next poll, e.g. right after a commit.
+func (r *Relay) Wake() {
+	select {
+	case r.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Relay) loop() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	var lastPurge time.Time
+	for {
+		for {
+			sent, err := r.Drain()
+			if err != nil {
+				log.Error().Err(err).Msg("failed to drain outbox")
+				break
+			}
+			// a batch holds one message per order key, keep going while messages are sent
+			if sent == 0 {
+				break
+			}
+			select {
+			case <-r.quit:
+				return
+			default:
+			}
+		}
+		if r.retention > 0 && time.Since(lastPurge) >= r.purgeInterval {
+			lastPurge = time.Now()
+			if _, err := r.Purge(); err != nil {
+				log.Error().Err(err).Msg("failed to purge outbox")
+			}
+		}
+
+		select {
+		case <-r.quit:
+			return
+		case <-ticker.C:
+		case <-r.wakeCh:
+		}
+	}
+}
+
+// db selects the outbox table explicitly; updates with a column map have no model to take it from.
+func (r *Relay) db() *gorm.DB {
+	return r.DB.Table(r.table())
+}
+
+func (r *Relay) table() string {
+	if r.tableName == "" {
+		return DefaultTableName
+	}
+	return r.tableName
+}
+
+// Drain publishes one batch of pending messages whose backoff has elapsed and returns how many were sent.
+// Of the messages sharing an OrderKey only the oldest pending one is taken, and none while it is backing off, so a
+// failing key holds back neither its own later messages nor the other keys.
+func (r *Relay) Drain() (sent int, err error) {
+	now := time.Now()
+	heads := r.DB.Table(r.table()).Select("MIN(id)").
+		Where("status = ? AND order_key <> ?", StatusPending, "").Group("order_key")
+	var rows []OutboxMessage
+	err = r.db().Where("status = ? AND next_attempt_at <= ?", StatusPending, now).
+		Where(r.DB.Where("order_key = ?", "").Or("id IN (?)", heads)).
+		Order("id").Limit(r.batchSize).Find(&rows).Error
+	if err != nil {
+		return
+	}
+
+	for i := range rows {
+		row := &rows[i]
+		err = r.publish(row)
+		if err != nil {
+			err = r.markRetry(row, err)
+		} else {
+			sent++
+			err = r.markSent(row)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (r *Relay) publish(row *OutboxMessage) error {
+	p, err := row.Publishing()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.publishTimeout)
+	defer cancel()
+	return r.Publisher.Publish(ctx, row.Exchange, row.RoutingKey, p)
+}
+
+func (r *Relay) markSent(row *OutboxMessage) error {
+	now := time.Now()
+	return r.db().Where("id = ?", row.ID).Updates(map[string]interface{}{
+		"status":   StatusSent,
+		"attempts": row.Attempts + 1,
+		"sent_at":  &now,
+	}).Error
+}
+
+func (r *Relay) markRetry(row *OutboxMessage, cause error) error {
+	attempts := row.Attempts + 1
+	status := StatusPending
+	if r.maxAttempts > 0 && attempts >= r.maxAttempts {
+		status = StatusFailed
+		log.Error().Err(cause).Uint64("id", row.ID).Int("attempts", attempts).Msg("outbox message failed permanently")
+	} else {
+		log.Warn().Err(cause).Uint64("id", row.ID).Int("attempts", attempts).Msg("failed to publish outbox message")
+	}
+	lastError := codec.Truncate(cause.Error(), 1024)
+	return r.db().Where("id = ?", row.ID).Updates(map[string]interface{}{
+		"status":          status,
+		"attempts":        attempts,
+		"next_attempt_at": time.Now().Add(r.backoff(attempts)),
+		"last_error":      lastError,
+	}).Error
+}
+
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := r.minBackoff
+	for i := 1; i < attempts && d < r.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.maxBackoff {
+		d = r.maxBackoff
+	}
+	return d
+}
+
+// Purge deletes sent messages older than the retention period.
+func (r *Relay) Purge() (int64, error) {
+	result := r.db().Where("status = ? AND sent_at < ?", StatusSent, time.Now().Add(-r.retention)).Delete(&OutboxMessage{})
+	if result.Error == nil && result.RowsAffected > 0 {
+		log.Info().Int64("count", result.RowsAffected).Msg("purged outbox messages")
+	}
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,159 @@
+package outbox
+
+import (
+	"errors"
+	"github.com/rabbitmq/amqp091-go"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/utils/tests"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// No database driver is vendored, so the relay queries are checked as the SQL a dry run builds.
+
+type statement struct {
+	sql  string
+	vars []interface{}
+}
+
+// dryRunDB builds SQL without a database and records every statement.
+func dryRunDB(t *testing.T) (*gorm.DB, *[]statement) {
+	t.Helper()
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	callbacks.RegisterDefaultCallbacks(db, &callbacks.Config{})
+	var statements []statement
+	record := func(db *gorm.DB) {
+		statements = append(statements, statement{sql: db.Statement.SQL.String(), vars: db.Statement.Vars})
+	}
+	if err = db.Callback().Query().After("gorm:query").Register("test:record", record); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Callback().Update().After("gorm:update").Register("test:record", record); err != nil {
+		t.Fatal(err)
+	}
+	return db, &statements
+}
+
+func TestDrainTakesTheHeadOfEachOrderKey(t *testing.T) {
+	db, statements := dryRunDB(t)
+	r := NewRelay(db, nil, WithBatchSize(10))
+	if _, err := r.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*statements) == 0 {
+		t.Fatal("no query")
+	}
+	sql := (*statements)[len(*statements)-1].sql
+	for _, want := range []string{
+		"status = ? AND next_attempt_at <= ?",
+		"(order_key = ?) OR id IN (SELECT MIN(id) FROM `outbox_messages` WHERE status = ? AND order_key <> ? GROUP BY `order_key`)",
+		"ORDER BY id LIMIT 10",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("drain query %s does not contain %s", sql, want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	r := NewRelay(nil, nil, WithBackoff(time.Second, 10*time.Second))
+	for attempts, want := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 8 * time.Second,
+		5: 10 * time.Second,
+		9: 10 * time.Second,
+	} {
+		if got := r.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestMarkRetry(t *testing.T) {
+	db, statements := dryRunDB(t)
+	r := NewRelay(db, nil, WithBackoff(time.Second, time.Minute), WithMaxAttempts(3))
+
+	// a long error is cut at 1024 bytes without splitting a character
+	cause := errors.New("a" + strings.Repeat("€", 400))
+	if err := r.markRetry(&OutboxMessage{ID: 1, Attempts: 1}, cause); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.markRetry(&OutboxMessage{ID: 2, Attempts: 2}, cause); err != nil {
+		t.Fatal(err)
+	}
+	if len(*statements) != 2 {
+		t.Fatalf("statements %v, want two updates", *statements)
+	}
+	for i, want := range []struct {
+		attempts int
+		status   int
+	}{{2, StatusPending}, {3, StatusFailed}} {
+		s := (*statements)[i]
+		if s.sql != "UPDATE `outbox_messages` SET `attempts`=?,`last_error`=?,`next_attempt_at`=?,`status`=? WHERE id = ?" {
+			t.Fatalf("update %s does not set the retry columns", s.sql)
+		}
+		if s.vars[0] != want.attempts || s.vars[3] != want.status {
+			t.Errorf("update %d sets attempts %v and status %v, want %d and %d", i, s.vars[0], s.vars[3], want.attempts, want.status)
+		}
+		lastError := s.vars[1].(string)
+		if len(lastError) > 1024 || !utf8.ValidString(lastError) || !strings.HasPrefix(lastError, "a€") {
+			t.Errorf("last_error of %d bytes is not the error cut at a character boundary", len(lastError))
+		}
+	}
+}
+
+func TestPublishingKeepsHeaderTypes(t *testing.T) {
+	when := time.Date(2024, 5, 6, 7, 8, 9, 123000000, time.UTC)
+	headers := amqp091.Table{
+		"nil":     nil,
+		"bool":    true,
+		"byte":    byte(1),
+		"int8":    int8(-2),
+		"int16":   int16(300),
+		"int32":   int32(70000),
+		"int64":   int64(1) << 40,
+		"float32": float32(1.5),
+		"float64": 2.25,
+		"string":  "s",
+		"bytes":   []byte{1, 2},
+		"decimal": amqp091.Decimal{Scale: 2, Value: 1234},
+		"time":    when,
+		"array":   []interface{}{int32(1), "two", amqp091.Table{"three": int16(3)}},
+		"table":   amqp091.Table{"nested": amqp091.Table{"deep": int64(4)}},
+	}
+	row, err := toRow(Message{Exchange: "orders", Key: "created", Publishing: amqp091.Publishing{Headers: headers}}, when)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := row.Publishing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.Headers, headers) {
+		t.Errorf("headers %#v, want %#v", p.Headers, headers)
+	}
+
+	_, err = toRow(Message{Publishing: amqp091.Publishing{Headers: amqp091.Table{"bad": struct{}{}}}}, when)
+	if err == nil {
+		t.Error("enqueued a header amqp091 cannot send")
+	}
+
+	// rows of older versions hold plain JSON
+	legacy := OutboxMessage{Headers: []byte(`{"n":1,"nested":{"s":"x"}}`)}
+	p, err = legacy.Publishing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Headers["n"] != float64(1) || p.Headers["nested"].(amqp091.Table)["s"] != "x" {
+		t.Errorf("legacy headers %#v", p.Headers)
+	}
+}
@@ -0,0 +1,55 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// BatchMessage is one message of PublishBatch.
+type BatchMessage struct {
+	Exchange   string
+	Key        string
+	Publishing amqp091.Publishing
+}
+
+// BatchResult holds the publish error of each message, in input order. nil means published (and confirmed in
+// confirm mode).
+type BatchResult []error
+
+// Failed returns the indexes of the messages that failed.
+func (r BatchResult) Failed() []int {
+	var failed []int
+	for i, err := range r {
+		if err != nil {
+			failed = append(failed, i)
+		}
+	}
+	return failed
+}
+
+// Err summarizes the failures, nil if every message succeeded.
+func (r BatchResult) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d messages failed, first at %d: %w", len(failed), len(r), failed[0], r[failed[0]])
+}
+
+// PublishBatch publishes all messages without waiting in between, then waits for every confirmation.
+// Middlewares are not applied.
+func (c *ReliableRabbitPublisher) PublishBatch(ctx context.Context, msgs []BatchMessage) BatchResult {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	futures := make([]*PublishFuture, len(msgs))
+	for i, msg := range msgs {
+		futures[i] = c.PublishAsync(ctx, msg.Exchange, msg.Key, msg.Publishing)
+	}
+	result := make(BatchResult, len(msgs))
+	for i, future := range futures {
+		result[i] = future.Wait(ctx)
+	}
+	return result
+}
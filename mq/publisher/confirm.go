@@ -0,0 +1,295 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNacked is returned in confirm mode when the broker refuses a message.
+var ErrNacked = errors.New("message nacked by broker")
+
+// ReturnedError is returned when a mandatory message could not be routed to any queue.
+type ReturnedError struct {
+	Exchange   string
+	RoutingKey string
+	ReplyCode  uint16
+	ReplyText  string
+	MessageId  string
+}
+
+func (e *ReturnedError) Error() string {
+	return fmt.Sprintf("message %s returned by broker: exchange: %s, key: %s, code: %d, reason: %s",
+		e.MessageId, e.Exchange, e.RoutingKey, e.ReplyCode, e.ReplyText)
+}
+
+// PublisherStats are cumulative counters since Start.
+type PublisherStats struct {
+	Published uint64
+	Acked     uint64
+	Nacked    uint64
+	Returned  uint64
+	Failed    uint64
+}
+
+type publisherCounters struct {
+	published atomic.Uint64
+	acked     atomic.Uint64
+	nacked    atomic.Uint64
+	returned  atomic.Uint64
+	failed    atomic.Uint64
+}
+
+// PublishFuture is the pending result of PublishAsync.
+type PublishFuture struct {
+	done chan struct{}
+	err  error
+}
+
+func newPublishFuture() *PublishFuture {
+	return &PublishFuture{
+		done: make(chan struct{}),
+	}
+}
+
+// CompletedFuture returns a future already resolved with err.
+func CompletedFuture(err error) *PublishFuture {
+	f := newPublishFuture()
+	f.complete(err)
+	return f
+}
+
+func (f *PublishFuture) complete(err error) {
+	f.err = err
+	close(f.done)
+}
+
+// Done is closed once the result is known.
+func (f *PublishFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Err returns the publish result. Only valid after Done is closed.
+func (f *PublishFuture) Err() error {
+	return f.err
+}
+
+// Wait blocks until the result is known or ctx is done.
+func (f *PublishFuture) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for publish confirmation: %w", ctx.Err())
+	}
+}
+
+// publishOutcome is what the broker reported about one publishing before its confirmation reached settle. It
+// travels with the publishing in its context, so publishings sharing a MessageId do not mix up their outcomes.
+type publishOutcome struct {
+	returned *ReturnedError
+	nacked   bool
+}
+
+type publishOutcomeKey struct{}
+
+// pendingPublish is an unconfirmed publishing of a confirm channel.
+type pendingPublish struct {
+	exchange  string
+	key       string
+	messageId string
+	outcome   *publishOutcome
+}
+
+// inflight holds the unconfirmed publishings of a confirm channel by delivery tag.
+type inflight struct {
+	mu    sync.Mutex
+	byTag map[uint64]*pendingPublish
+}
+
+func newInflight() *inflight {
+	return &inflight{
+		byTag: make(map[uint64]*pendingPublish),
+	}
+}
+
+func (f *inflight) add(tag uint64, p *pendingPublish) {
+	f.mu.Lock()
+	f.byTag[tag] = p
+	f.mu.Unlock()
+}
+
+func (f *inflight) remove(tag uint64) *pendingPublish {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := f.byTag[tag]
+	delete(f.byTag, tag)
+	return p
+}
+
+// returned records the return on its publishing and reports whether one was found. A return carries no delivery
+// tag. The broker routes and returns the publishings of a channel in order, so the return belongs to the oldest
+// unconfirmed publishing with its MessageId, exchange and routing key that has not been returned yet.
+func (f *inflight) returned(ret *ReturnedError) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var oldest *pendingPublish
+	var oldestTag uint64
+	for tag, p := range f.byTag {
+		if p.outcome.returned != nil || p.messageId != ret.MessageId || p.exchange != ret.Exchange ||
+			p.key != ret.RoutingKey {
+			continue
+		}
+		if oldest == nil || tag < oldestTag {
+			oldest, oldestTag = p, tag
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	oldest.outcome.returned = ret
+	return true
+}
+
+// Stats returns the publish counters.
+func (c *ReliableRabbitPublisher) Stats() PublisherStats {
+	return PublisherStats{
+		Published: c.counters.published.Load(),
+		Acked:     c.counters.acked.Load(),
+		Nacked:    c.counters.nacked.Load(),
+		Returned:  c.counters.returned.Load(),
+		Failed:    c.counters.failed.Load(),
+	}
+}
+
+func (c *ReliableRabbitPublisher) watchReturns(channel *amqp091.Channel) {
+	returns := channel.NotifyReturn(make(chan amqp091.Return))
+	go func() {
+		for r := range returns {
+			log.Warn().Err(c.handleReturn(r)).Msg("unroutable message returned")
+		}
+	}()
+}
+
+// handleReturn counts a return, passes it to the return handler and describes it.
+func (c *ReliableRabbitPublisher) handleReturn(r amqp091.Return) *ReturnedError {
+	c.counters.returned.Add(1)
+	if c.returnHandler != nil {
+		c.returnHandler(r)
+	}
+	return &ReturnedError{
+		Exchange:   r.Exchange,
+		RoutingKey: r.RoutingKey,
+		ReplyCode:  r.ReplyCode,
+		ReplyText:  r.ReplyText,
+		MessageId:  r.MessageId,
+	}
+}
+
+// confirmChannel is the publishing channel in confirm mode. It reads basic.return and basic.ack/nack in one loop,
+// in the order the broker sent them, and records the outcome of a publishing before amqpextra sees its
+// confirmation. The broker sends the return of a mandatory message before its ack, so settle never misses it.
+type confirmChannel struct {
+	*amqp091.Channel
+	c        *ReliableRabbitPublisher
+	inflight *inflight
+}
+
+func newConfirmChannel(channel *amqp091.Channel, c *ReliableRabbitPublisher) *confirmChannel {
+	return &confirmChannel{
+		Channel:  channel,
+		c:        c,
+		inflight: newInflight(),
+	}
+}
+
+// PublishWithContext remembers the outcome of the publishing under its delivery tag. amqpextra publishes from one
+// goroutine, so the next sequence number is the tag the broker confirms.
+func (ch *confirmChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool,
+	msg amqp091.Publishing) error {
+	tag := ch.Channel.GetNextPublishSeqNo()
+	outcome, tracked := ctx.Value(publishOutcomeKey{}).(*publishOutcome)
+	if tracked {
+		ch.inflight.add(tag, &pendingPublish{exchange: exchange, key: key, messageId: msg.MessageId, outcome: outcome})
+	}
+	err := ch.Channel.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+	if err != nil && tracked {
+		ch.inflight.remove(tag)
+	}
+	return err
+}
+
+// NotifyPublish is called by amqpextra after Confirm. confirm receives the confirmations once their returns and
+// nacks are recorded.
+func (ch *confirmChannel) NotifyPublish(confirm chan amqp091.Confirmation) chan amqp091.Confirmation {
+	confirmations := ch.Channel.NotifyPublish(make(chan amqp091.Confirmation, cap(confirm)))
+	returns := ch.Channel.NotifyReturn(make(chan amqp091.Return))
+	closed := ch.Channel.NotifyClose(make(chan *amqp091.Error, 1))
+	go ch.dispatch(confirmations, returns, closed, confirm)
+	return confirm
+}
+
+func (ch *confirmChannel) dispatch(confirmations <-chan amqp091.Confirmation, returns <-chan amqp091.Return,
+	closed <-chan *amqp091.Error, confirm chan<- amqp091.Confirmation) {
+	defer close(confirm)
+	for {
+		select {
+		case r, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			if ret := ch.c.handleReturn(r); !ch.inflight.returned(ret) {
+				log.Warn().Err(ret).Msg("unroutable message returned")
+			}
+		case confirmation, ok := <-confirmations:
+			if !ok {
+				return
+			}
+			if p := ch.inflight.remove(confirmation.DeliveryTag); p != nil && !confirmation.Ack {
+				p.outcome.nacked = true
+			}
+			select {
+			case confirm <- confirmation:
+			case <-closed:
+				// amqpextra gave up on the channel, nobody waits for the remaining confirmations
+				return
+			}
+		}
+	}
+}
+
+// settle turns the raw result of amqpextra into the publisher's typed errors and updates counters.
+// outcome is nil outside confirm mode. It is complete once amqpextra reports the confirmation.
+func (c *ReliableRabbitPublisher) settle(err error, outcome *publishOutcome) error {
+	if outcome == nil {
+		outcome = &publishOutcome{}
+	}
+	switch {
+	case outcome.nacked:
+		c.counters.nacked.Add(1)
+		return ErrNacked
+	case err == nil:
+		if c.confirm {
+			c.counters.acked.Add(1)
+		}
+		if outcome.returned != nil {
+			return outcome.returned
+		}
+		return nil
+	default:
+		c.counters.failed.Add(1)
+		return err
+	}
+}
+
+func ensureMessageId(msg *amqp091.Publishing) {
+	if msg.MessageId == "" {
+		msg.MessageId = uuid.NewString()
+	}
+}
@@ -0,0 +1,51 @@
+package publisher
+
+import (
+	"github.com/latifrons/amqpextra/logger"
+	"testing"
+)
+
+func TestInflightReturnsPerPublish(t *testing.T) {
+	f := newInflight()
+	first := &publishOutcome{}
+	second := &publishOutcome{}
+	other := &publishOutcome{}
+	f.add(1, &pendingPublish{exchange: "ex", key: "a", messageId: "id", outcome: first})
+	f.add(2, &pendingPublish{exchange: "ex", key: "b", messageId: "id", outcome: other})
+	f.add(3, &pendingPublish{exchange: "ex", key: "a", messageId: "id", outcome: second})
+
+	// publishings sharing a MessageId and a route are returned in publish order
+	for _, want := range []*publishOutcome{first, second} {
+		ret := &ReturnedError{Exchange: "ex", RoutingKey: "a", MessageId: "id"}
+		if !f.returned(ret) || want.returned != ret {
+			t.Fatalf("return not recorded on the oldest unreturned publishing")
+		}
+	}
+	if other.returned != nil {
+		t.Error("return recorded on a publishing to another routing key")
+	}
+	if f.returned(&ReturnedError{Exchange: "ex", RoutingKey: "a", MessageId: "id"}) {
+		t.Error("third return recorded, only two publishings were sent")
+	}
+
+	if p := f.remove(2); p == nil || p.outcome != other {
+		t.Fatalf("remove(2) = %v, want the publishing of tag 2", p)
+	}
+	if p := f.remove(2); p != nil {
+		t.Errorf("second remove(2) = %v, want nil", p)
+	}
+}
+
+func TestSettleOutcome(t *testing.T) {
+	p := NewReliableRabbitPublisher("amqp://127.0.0.1:1/", WithLogger(logger.Discard))
+	if err := p.settle(nil, &publishOutcome{}); err != nil {
+		t.Errorf("settle of an ack = %v, want nil", err)
+	}
+	ret := &ReturnedError{RoutingKey: "a"}
+	if err := p.settle(nil, &publishOutcome{returned: ret}); err != ret {
+		t.Errorf("settle of a returned publishing = %v, want the return", err)
+	}
+	if err := p.settle(nil, &publishOutcome{nacked: true}); err != ErrNacked {
+		t.Errorf("settle of a nacked publishing = %v, want ErrNacked", err)
+	}
+}
@@ -0,0 +1,67 @@
+package publisher
+
+import (
+	"context"
+	"github.com/latifrons/latigo/mq/correlation"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+type PublishFunc func(ctx context.Context, exchange, key string, msg amqp091.Publishing) error
+
+// Middleware wraps Publish. PublishAsync does not go through middlewares.
+type Middleware func(next PublishFunc) PublishFunc
+
+// Chain wraps p with the middlewares. The first middleware is the outermost one.
+func Chain(p PublishFunc, middlewares ...Middleware) PublishFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		p = middlewares[i](p)
+	}
+	return p
+}
+
+func WithMiddleware(middlewares ...Middleware) PublisherOption {
+	return func(c *ReliableRabbitPublisher) {
+		c.middlewares = append(c.middlewares, middlewares...)
+	}
+}
+
+// CorrelationMiddleware copies the correlation id of ctx into the message headers unless already set.
+func CorrelationMiddleware() Middleware {
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, exchange, key string, msg amqp091.Publishing) error {
+			if id := correlation.FromContext(ctx); id != "" {
+				if _, ok := msg.Headers[correlation.Header]; !ok {
+					headers := amqp091.Table{}
+					for k, v := range msg.Headers {
+						headers[k] = v
+					}
+					headers[correlation.Header] = id
+					msg.Headers = headers
+				}
+			}
+			return next(ctx, exchange, key, msg)
+		}
+	}
+}
+
+// LoggingMiddleware logs each publish at the given level, and failures as errors.
+func LoggingMiddleware(level zerolog.Level) Middleware {
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, exchange, key string, msg amqp091.Publishing) error {
+			start := time.Now()
+			err := next(ctx, exchange, key, msg)
+			var event *zerolog.Event
+			if err != nil {
+				event = log.Error().Err(err)
+			} else {
+				event = log.WithLevel(level)
+			}
+			event.Str("exchange", exchange).Str("routingKey", key).Str("messageId", msg.MessageId).
+				Str("correlationId", correlation.FromContext(ctx)).Dur("elapsed", time.Since(start)).Msg("message published")
+			return err
+		}
+	}
+}
@@ -2,11 +2,16 @@ package publisher
 
 import (
 	"context"
+	"fmt"
 	"github.com/latifrons/amqpextra"
 	"github.com/latifrons/amqpextra/logger"
 	pp "github.com/latifrons/amqpextra/publisher"
+	"github.com/latifrons/latigo/mq/connstate"
+	"github.com/latifrons/latigo/mq/topology"
 	"github.com/rabbitmq/amqp091-go"
 	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
 )
 
 type DeclareExchangeArgs struct {
@@ -21,18 +26,40 @@ type DeclareExchangeArgs struct {
 
 type PublisherOption func(*ReliableRabbitPublisher)
 
+// MessagePublisher is the publishing surface shared by ReliableRabbitPublisher and test doubles.
+type MessagePublisher interface {
+	Publish(ctx context.Context, exchange, key string, msg amqp091.Publishing) error
+	PublishAsync(ctx context.Context, exchange, key string, msg amqp091.Publishing) *PublishFuture
+}
+
+var _ MessagePublisher = (*ReliableRabbitPublisher)(nil)
+
 type ReliableRabbitPublisher struct {
 	URL                 string
 	DeclareExchangeArgs DeclareExchangeArgs
+	Topology            topology.Topology
 
-	logger    logger.Logger
-	dailer    *amqpextra.Dialer
-	publisher *pp.Publisher
+	initFunc      func(channel *amqp091.Channel) (err error)
+	logger        logger.Logger
+	dailer        *amqpextra.Dialer
+	publisher     *pp.Publisher
+	confirm       bool
+	confirmBuffer uint
+	mandatory     bool
+	returnHandler func(ret amqp091.Return)
+	middlewares   []Middleware
+	startTimeout  time.Duration
+	tracker       *connstate.Tracker
+	quit          chan struct{}
+	watcherDone   chan struct{}
+	stopOnce      sync.Once
+	counters      publisherCounters
 }
 
 func NewReliableRabbitPublisher(url string, opts ...PublisherOption) *ReliableRabbitPublisher {
 	c := &ReliableRabbitPublisher{
-		URL: url,
+		URL:     url,
+		tracker: connstate.NewTracker(),
 	}
 	for _, opt := range opts {
 		opt(c)
@@ -52,8 +79,50 @@ func WithLogger(logger2 logger.Logger) PublisherOption {
 	}
 }
 
-func WithInitFunc(args func(channel *amqp091.Channel) (err error)) PublisherOption {
+// WithTopology declares the given topology on every (re)connect.
+func WithTopology(t topology.Topology) PublisherOption {
 	return func(c *ReliableRabbitPublisher) {
+		c.Topology = c.Topology.Merge(t)
+	}
+}
+
+// WithInitFunc runs f on the publishing channel on every (re)connect, after the topology has been declared.
+func WithInitFunc(f func(channel *amqp091.Channel) (err error)) PublisherOption {
+	return func(c *ReliableRabbitPublisher) {
+		c.initFunc = f
+	}
+}
+
+// WithConfirmMode enables publisher confirms. Publish then blocks until the broker acks or nacks the message.
+// buffer bounds the number of unconfirmed messages in flight. Messages without MessageId get a generated one to
+// correlate returns, which carry no delivery tag.
+func WithConfirmMode(buffer uint) PublisherOption {
+	return func(c *ReliableRabbitPublisher) {
+		c.confirm = true
+		c.confirmBuffer = buffer
+	}
+}
+
+// WithMandatory publishes every message as mandatory. In confirm mode an unroutable message makes Publish
+// return a *ReturnedError.
+func WithMandatory(mandatory bool) PublisherOption {
+	return func(c *ReliableRabbitPublisher) {
+		c.mandatory = mandatory
+	}
+}
+
+// WithReturnHandler is called for every message returned by the broker.
+func WithReturnHandler(handler func(ret amqp091.Return)) PublisherOption {
+	return func(c *ReliableRabbitPublisher) {
+		c.returnHandler = handler
+	}
+}
+
+// WithStartTimeout makes Start fail if the publisher is not ready within timeout. 0 returns immediately and keeps
+// retrying in the background.
+func WithStartTimeout(timeout time.Duration) PublisherOption {
+	return func(c *ReliableRabbitPublisher) {
+		c.startTimeout = timeout
 	}
 }
 
@@ -72,43 +141,135 @@ func (c *ReliableRabbitPublisher) Start() (err error) {
 		return
 	}
 
-	c.publisher, err = c.dailer.Publisher(
+	publisherChannel := make(chan pp.State, 10)
+	publisherOpts := []pp.Option{
 		pp.WithLogger(c.logger),
 		pp.WithInitFunc(c.initer),
-	)
+		pp.WithNotify(publisherChannel),
+	}
+	if c.confirm {
+		publisherOpts = append(publisherOpts, pp.WithConfirmation(c.confirmBuffer))
+	}
+	c.publisher, err = c.dailer.Publisher(publisherOpts...)
 	if err != nil {
+		c.dailer.Close()
 		return
 	}
 
-	go func() {
-		for {
-			select {
-			case v := <-dialerChannel:
-				log.Info().Interface("v", v).Msg("dialer updates")
+	c.quit = make(chan struct{})
+	c.watcherDone = make(chan struct{})
+	go c.watch(dialerChannel, publisherChannel)
+
+	if c.startTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), c.startTimeout)
+		defer cancel()
+		err = c.WaitReady(ctx)
+		if err != nil {
+			c.Stop()
+			return fmt.Errorf("publisher not ready within %s: %w", c.startTimeout, err)
+		}
+	}
+	return
+}
+
+func (c *ReliableRabbitPublisher) watch(dialerChannel chan amqpextra.State, publisherChannel chan pp.State) {
+	defer close(c.watcherDone)
+	for {
+		select {
+		case v := <-dialerChannel:
+			log.Info().Interface("v", v).Msg("dialer updates")
+			if v.Unready != nil {
+				c.tracker.Set(connstate.StatusUnready, v.Unready.Err)
 			}
+		case v := <-publisherChannel:
+			log.Info().Interface("v", v).Msg("publisher updates")
+			if v.Ready != nil {
+				c.tracker.Set(connstate.StatusReady, nil)
+			} else if v.Unready != nil {
+				c.tracker.Set(connstate.StatusUnready, v.Unready.Err)
+			}
+		case <-c.quit:
+			return
 		}
+	}
+}
 
-	}()
+// State returns the current connection state.
+func (c *ReliableRabbitPublisher) State() connstate.State {
+	return c.tracker.State()
+}
 
-	return
+// Subscribe returns a channel of connection state changes. Call cancel when no longer interested.
+func (c *ReliableRabbitPublisher) Subscribe() (<-chan connstate.State, func()) {
+	return c.tracker.Subscribe()
+}
+
+// WaitReady blocks until the publisher can publish, it is stopped or ctx is done.
+func (c *ReliableRabbitPublisher) WaitReady(ctx context.Context) error {
+	return c.tracker.WaitReady(ctx)
+}
+
+// Healthy returns nil while the publisher is ready.
+func (c *ReliableRabbitPublisher) Healthy() error {
+	return c.tracker.Healthy()
 }
 
 func (c *ReliableRabbitPublisher) Publish(ctx context.Context, exchange, key string, msg amqp091.Publishing) (err error) {
-	return c.publisher.Publish(pp.Message{
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return Chain(c.publishAndWait, c.middlewares...)(ctx, exchange, key, msg)
+}
+
+func (c *ReliableRabbitPublisher) publishAndWait(ctx context.Context, exchange, key string, msg amqp091.Publishing) error {
+	return c.PublishAsync(ctx, exchange, key, msg).Wait(ctx)
+}
+
+// PublishAsync hands the message to the publisher and returns without waiting for the broker confirmation.
+func (c *ReliableRabbitPublisher) PublishAsync(ctx context.Context, exchange, key string, msg amqp091.Publishing) *PublishFuture {
+	var outcome *publishOutcome
+	if c.confirm {
+		ensureMessageId(&msg)
+		outcome = &publishOutcome{}
+		ctx = context.WithValue(ctx, publishOutcomeKey{}, outcome)
+	}
+	future := newPublishFuture()
+	resultCh := c.publisher.Go(pp.Message{
 		Context:      ctx,
 		Exchange:     exchange,
 		Key:          key,
-		Mandatory:    false,
+		Mandatory:    c.mandatory,
 		Immediate:    false,
 		ErrOnUnready: false,
 		Publishing:   msg,
-		ResultCh:     nil,
+		ResultCh:     make(chan error, 1),
 	})
+	c.counters.published.Add(1)
+	go func() {
+		future.complete(c.settle(<-resultCh, outcome))
+	}()
+	return future
 }
 
+// Stop stops publishing and waits for the messages handed to the publisher. It may be called more than once,
+// also after Start failed or without Start. A stopped publisher cannot be started again.
 func (c *ReliableRabbitPublisher) Stop() {
-	c.publisher.Close()
-	c.dailer.Close()
+	c.stopOnce.Do(c.stop)
+}
+
+func (c *ReliableRabbitPublisher) stop() {
+	if c.publisher != nil {
+		c.publisher.Close()
+		<-c.publisher.NotifyClosed()
+	}
+	if c.quit != nil {
+		close(c.quit)
+		<-c.watcherDone
+	}
+	c.tracker.Close()
+	if c.dailer != nil {
+		c.dailer.Close()
+	}
 }
 
 func (c *ReliableRabbitPublisher) initer(conn pp.AMQPConnection) (channelP pp.AMQPChannel, err error) {
@@ -116,10 +277,43 @@ func (c *ReliableRabbitPublisher) initer(conn pp.AMQPConnection) (channelP pp.AM
 	if err != nil {
 		return
 	}
-	// declare exchange
-	err = channel.ExchangeDeclare(c.DeclareExchangeArgs.ExchangeName, c.DeclareExchangeArgs.Kind, c.DeclareExchangeArgs.Durable, c.DeclareExchangeArgs.AutoDelete, c.DeclareExchangeArgs.Internal, c.DeclareExchangeArgs.NoWait, c.DeclareExchangeArgs.Args)
+	if !c.confirm {
+		c.watchReturns(channel)
+	}
+
+	err = c.topology().Declare(channel)
 	if err != nil {
+		_ = channel.Close()
 		return
 	}
+	if c.initFunc != nil {
+		err = c.initFunc(channel)
+		if err != nil {
+			_ = channel.Close()
+			return
+		}
+	}
+	if c.confirm {
+		return newConfirmChannel(channel, c), nil
+	}
 	return channel, err
 }
+
+// topology returns the declared topology including the legacy DeclareExchangeArgs exchange.
+func (c *ReliableRabbitPublisher) topology() topology.Topology {
+	if c.DeclareExchangeArgs.ExchangeName == "" {
+		return c.Topology
+	}
+	legacy := topology.Topology{
+		Exchanges: []topology.Exchange{{
+			Name:       c.DeclareExchangeArgs.ExchangeName,
+			Kind:       c.DeclareExchangeArgs.Kind,
+			Durable:    c.DeclareExchangeArgs.Durable,
+			AutoDelete: c.DeclareExchangeArgs.AutoDelete,
+			Internal:   c.DeclareExchangeArgs.Internal,
+			NoWait:     c.DeclareExchangeArgs.NoWait,
+			Args:       c.DeclareExchangeArgs.Args,
+		}},
+	}
+	return legacy.Merge(c.Topology)
+}
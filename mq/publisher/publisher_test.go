@@ -0,0 +1,26 @@
+package publisher
+
+import (
+	"github.com/latifrons/amqpextra/logger"
+	"github.com/latifrons/latigo/mq/connstate"
+	"testing"
+	"time"
+)
+
+func TestStopWithoutStart(t *testing.T) {
+	p := NewReliableRabbitPublisher("amqp://127.0.0.1:1/", WithLogger(logger.Discard))
+	p.Stop()
+	p.Stop()
+	if p.State().Status != connstate.StatusClosed {
+		t.Errorf("state %v after Stop, want closed", p.State())
+	}
+}
+
+func TestStopAfterStartTimeout(t *testing.T) {
+	p := NewReliableRabbitPublisher("amqp://127.0.0.1:1/", WithLogger(logger.Discard), WithStartTimeout(50*time.Millisecond))
+	if err := p.Start(); err == nil {
+		t.Fatal("started without a broker")
+	}
+	// Start already stopped the publisher
+	p.Stop()
+}
@@ -0,0 +1,37 @@
+package publisher
+
+import (
+	"context"
+	"github.com/latifrons/latigo/mq/codec"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// TypedPublisher publishes values of type T to one exchange, encoded with a codec.
+type TypedPublisher[T any] struct {
+	Publisher     *ReliableRabbitPublisher
+	Exchange      string
+	Codec         codec.Codec
+	SchemaVersion string
+}
+
+func NewTypedPublisher[T any](publisher *ReliableRabbitPublisher, exchange string, c codec.Codec, schemaVersion string) *TypedPublisher[T] {
+	return &TypedPublisher[T]{
+		Publisher:     publisher,
+		Exchange:      exchange,
+		Codec:         c,
+		SchemaVersion: schemaVersion,
+	}
+}
+
+func (p *TypedPublisher[T]) Publish(ctx context.Context, key string, v T) error {
+	return p.PublishWith(ctx, key, v, amqp091.Publishing{})
+}
+
+// PublishWith uses template for the message properties and headers. Body and ContentType are overwritten.
+func (p *TypedPublisher[T]) PublishWith(ctx context.Context, key string, v T, template amqp091.Publishing) error {
+	msg, err := codec.Encode(p.Codec, v, p.SchemaVersion, template)
+	if err != nil {
+		return err
+	}
+	return p.Publisher.Publish(ctx, p.Exchange, key, msg)
+}
@@ -0,0 +1,241 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/latifrons/latigo/mq/codec"
+	"github.com/latifrons/latigo/mq/consumer"
+	"github.com/latifrons/latigo/mq/correlation"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type ClientOption func(*Client)
+
+// WithCodec sets the request codec. Replies are decoded by their content type. Defaults to codec.JSON.
+func WithCodec(c codec.Codec) ClientOption {
+	return func(client *Client) {
+		client.Codec = c
+	}
+}
+
+// WithDefaultTimeout bounds calls whose context has no deadline.
+func WithDefaultTimeout(timeout time.Duration) ClientOption {
+	return func(client *Client) {
+		client.DefaultTimeout = timeout
+	}
+}
+
+// WithExclusiveReplyQueue makes NewRabbitClient receive replies on an exclusive, auto-deleted queue named
+// "<prefix>.<uuid>" instead of direct reply-to.
+func WithExclusiveReplyQueue(prefix string) ClientOption {
+	return func(client *Client) {
+		client.replyPrefix = prefix
+	}
+}
+
+// WithConsumerOptions passes options to the reply consumer of NewRabbitClient.
+func WithConsumerOptions(opts ...consumer.ConsumerOption) ClientOption {
+	return func(client *Client) {
+		client.consumerOpts = append(client.consumerOpts, opts...)
+	}
+}
+
+// Client sends requests and waits for the correlated replies. Replies must be fed to HandleReply.
+// Requests are not mandatory: an unroutable request fails by its deadline.
+type Client struct {
+	Codec          codec.Codec
+	ReplyTo        string
+	DefaultTimeout time.Duration
+
+	channel      func() consumer.ChannelPublisher
+	ready        func(ctx context.Context) error
+	autoAck      bool
+	replyPrefix  string
+	consumerOpts []consumer.ConsumerOption
+
+	mu      sync.Mutex
+	pending map[string]chan amqp091.Delivery
+}
+
+// NewClient creates a client publishing requests on the channel returned by channel, nil while disconnected,
+// with replies addressed to the queue replyTo.
+func NewClient(replyTo string, channel func() consumer.ChannelPublisher, opts ...ClientOption) *Client {
+	c := &Client{
+		Codec:   codec.JSON,
+		ReplyTo: replyTo,
+		channel: channel,
+		pending: make(map[string]chan amqp091.Delivery),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// RabbitClient is a Client with its own reply consumer. Requests are published on the consumer channel,
+// which direct reply-to requires.
+type RabbitClient struct {
+	*Client
+	*consumer.ReliableRabbitConsumer
+}
+
+// NewRabbitClient creates a client receiving replies by direct reply-to, or on an exclusive queue with
+// WithExclusiveReplyQueue. Start it before calling. Calls wait until the reply consumer is consuming.
+func NewRabbitClient(url string, opts ...ClientOption) *RabbitClient {
+	rc := &RabbitClient{}
+	rc.Client = NewClient(DirectReplyTo, func() consumer.ChannelPublisher {
+		if channel := rc.Channel(); channel != nil && rc.State().Ready() {
+			return channel
+		}
+		return nil
+	}, opts...)
+	// direct reply-to refuses requests published before basic.consume on the reply queue
+	rc.ready = func(ctx context.Context) error {
+		return rc.WaitReady(ctx)
+	}
+
+	var consumerOpts []consumer.ConsumerOption
+	if rc.replyPrefix != "" {
+		rc.ReplyTo = rc.replyPrefix + "." + uuid.NewString()
+		consumerOpts = append(consumerOpts,
+			consumer.WithDeclaredQueueArgs(consumer.DeclaredQueueArgs{Name: rc.ReplyTo, AutoDelete: true, Exclusive: true}),
+			consumer.WithConsumerArgs(consumer.ConsumerArgs{QueueName: rc.ReplyTo, Exclusive: true}))
+	} else {
+		// direct reply-to only works in no-ack mode
+		rc.autoAck = true
+		consumerOpts = append(consumerOpts, consumer.WithConsumerArgs(consumer.ConsumerArgs{QueueName: DirectReplyTo, AutoAck: true}))
+	}
+	rc.ReliableRabbitConsumer = consumer.NewReliableRabbitConsumer(url, rc.HandleReply, append(consumerOpts, rc.consumerOpts...)...)
+	return rc
+}
+
+func (c *RabbitClient) Name() string {
+	return "RpcClient"
+}
+
+// Call publishes req to exchange with routing key key and decodes the reply into resp, which may be nil.
+// A failure reported by the server is returned as a *grpcserver.GError.
+func (c *Client) Call(ctx context.Context, exchange, key string, req interface{}, resp interface{}) error {
+	reply, err := c.call(ctx, exchange, key, req)
+	if err != nil || resp == nil {
+		return err
+	}
+	return codec.Decode(reply, c.Codec, resp)
+}
+
+func (c *Client) call(ctx context.Context, exchange, key string, req interface{}) (reply amqp091.Delivery, err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, ok := ctx.Deadline(); !ok && c.DefaultTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.DefaultTimeout)
+		defer cancel()
+	}
+
+	if c.ready != nil {
+		err = c.ready(ctx)
+		if err != nil {
+			err = fmt.Errorf("rpc %s/%s: reply consumer not ready: %w", exchange, key, err)
+			return
+		}
+	}
+	channel := c.channel()
+	if channel == nil {
+		err = fmt.Errorf("rpc: reply consumer not ready")
+		return
+	}
+	msg, err := codec.Encode(c.Codec, req, "", amqp091.Publishing{})
+	if err != nil {
+		return
+	}
+	id := correlation.NewID()
+	msg.CorrelationId = id
+	msg.MessageId = id
+	msg.ReplyTo = c.ReplyTo
+	msg.Timestamp = time.Now()
+	msg.Headers = amqp091.Table{}
+	if cid := correlation.FromContext(ctx); cid != "" {
+		msg.Headers[correlation.Header] = cid
+	}
+	if d, ok := ctx.Deadline(); ok {
+		ttl := time.Until(d).Milliseconds()
+		if ttl <= 0 {
+			err = fmt.Errorf("rpc %s/%s: %w", exchange, key, context.DeadlineExceeded)
+			return
+		}
+		msg.Headers[HeaderDeadline] = d.UnixMilli()
+		// let the broker drop requests nobody waits for any more
+		msg.Expiration = strconv.FormatInt(ttl, 10)
+	}
+
+	replyCh := make(chan amqp091.Delivery, 1)
+	c.mu.Lock()
+	c.pending[id] = replyCh
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	err = channel.PublishWithContext(ctx, exchange, key, false, false, msg)
+	if err != nil {
+		return
+	}
+
+	select {
+	case reply = <-replyCh:
+		err = replyError(reply)
+	case <-ctx.Done():
+		err = fmt.Errorf("rpc %s/%s: %w", exchange, key, ctx.Err())
+	}
+	return
+}
+
+// HandleReply routes a reply to its waiting call. Replies nobody waits for are dropped.
+func (c *Client) HandleReply(ctx context.Context, msg amqp091.Delivery) interface{} {
+	c.mu.Lock()
+	replyCh, ok := c.pending[msg.CorrelationId]
+	c.mu.Unlock()
+	if ok {
+		select {
+		case replyCh <- msg:
+		default:
+		}
+	} else {
+		log.Debug().Str("correlationId", msg.CorrelationId).Msg("dropping rpc reply without waiting call")
+	}
+	if c.autoAck {
+		return nil
+	}
+	return msg.Ack(false)
+}
+
+// TypedClient calls one endpoint with typed requests and responses.
+type TypedClient[Req any, Resp any] struct {
+	Client   *Client
+	Exchange string
+	Key      string
+}
+
+func NewTypedClient[Req any, Resp any](client *Client, exchange string, key string) *TypedClient[Req, Resp] {
+	return &TypedClient[Req, Resp]{
+		Client:   client,
+		Exchange: exchange,
+		Key:      key,
+	}
+}
+
+func (t *TypedClient[Req, Resp]) Call(ctx context.Context, req Req) (resp Resp, err error) {
+	reply, err := t.Client.call(ctx, t.Exchange, t.Key, req)
+	if err != nil {
+		return
+	}
+	return codec.DecodeAs[Resp](reply, t.Client.Codec)
+}
@@ -0,0 +1,72 @@
+// Package rpc implements request/reply over RabbitMQ. Requests carry a correlation id and a reply address,
+// servers answer on the default exchange, and failures travel as grpcserver.GError so callers can map them to
+// HTTP responses the same way as gRPC failures.
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/latifrons/latigo/grpcserver"
+	"github.com/latifrons/latigo/mq/codec"
+	"github.com/rabbitmq/amqp091-go"
+	"time"
+)
+
+const (
+	// DirectReplyTo is the RabbitMQ pseudo queue for direct reply-to.
+	DirectReplyTo = "amq.rabbitmq.reply-to"
+	// HeaderStatus is StatusOK or StatusError on replies.
+	HeaderStatus = "x-rpc-status"
+	// HeaderDeadline carries the caller deadline in unix milliseconds.
+	HeaderDeadline = "x-rpc-deadline"
+)
+
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// ToGError converts a handler error for transport with the mapping of grpcserver.WrapGRpcError, so a failure maps
+// to the same HTTP response over RabbitMQ and over gRPC.
+func ToGError(module string, err error) *grpcserver.GError {
+	return grpcserver.ToGError(module, err)
+}
+
+func errorPublishing(gerr *grpcserver.GError) amqp091.Publishing {
+	body, _ := json.Marshal(gerr)
+	return amqp091.Publishing{
+		Headers:     amqp091.Table{HeaderStatus: StatusError},
+		ContentType: codec.ContentTypeJSON,
+		Body:        body,
+	}
+}
+
+// replyError returns the transported error of a reply, nil for successful replies.
+func replyError(reply amqp091.Delivery) error {
+	if status, _ := reply.Headers[HeaderStatus].(string); status != StatusError {
+		return nil
+	}
+	gerr, ok := grpcserver.Parse(string(reply.Body))
+	if !ok {
+		return fmt.Errorf("rpc: malformed error reply: %s", codec.Truncate(string(reply.Body), 256))
+	}
+	return gerr
+}
+
+// deadline reads HeaderDeadline.
+func deadline(msg amqp091.Delivery) (time.Time, bool) {
+	var ms int64
+	switch v := msg.Headers[HeaderDeadline].(type) {
+	case int64:
+		ms = v
+	case int32:
+		ms = int64(v)
+	case int:
+		ms = int64(v)
+	case float64:
+		ms = int64(v)
+	default:
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms), true
+}
@@ -0,0 +1,34 @@
+package rpc
+
+import (
+	"errors"
+	"github.com/latifrons/latigo/berror"
+	"github.com/latifrons/latigo/grpcserver"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"reflect"
+	"testing"
+)
+
+// ToGError must map like grpcserver.WrapGRpcError, so rpcserver answers a failure over RabbitMQ and over gRPC alike.
+func TestToGErrorMatchesGRpc(t *testing.T) {
+	for name, err := range map[string]error{
+		"business":  berror.NewBusinessFail(nil, berror.ErrBadRequest, "bad order"),
+		"temporary": berror.NewBusinessTemporary(errors.New("locked"), "ErrLocked", "order locked"),
+		"system":    berror.NewSystemTemporary(errors.New("timeout"), berror.ErrInternal, "db unavailable"),
+		"plain":     errors.New("boom"),
+		"gerror":    grpcserver.NewGError("other", "ErrX", "x", "debug", "", nil, grpcserver.Category_Retriable),
+	} {
+		s, ok := status.FromError(grpcserver.WrapGRpcError("orders", codes.FailedPrecondition, err))
+		if !ok {
+			t.Fatalf("%s: WrapGRpcError did not return a status", name)
+		}
+		want, ok := grpcserver.Parse(s.Message())
+		if !ok {
+			t.Fatalf("%s: status message %q is not a GError", name, s.Message())
+		}
+		if got := ToGError("orders", err); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: ToGError = %+v, gRPC sends %+v", name, got, want)
+		}
+	}
+}
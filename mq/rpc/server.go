@@ -0,0 +1,103 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/latifrons/latigo/berror"
+	"github.com/latifrons/latigo/grpcserver"
+	"github.com/latifrons/latigo/mq/codec"
+	"github.com/latifrons/latigo/mq/consumer"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// HandlerFunc serves one request. A returned error is sent to the caller as a *grpcserver.GError, see ToGError.
+type HandlerFunc[Req any, Resp any] func(ctx context.Context, req Req) (Resp, error)
+
+// NewServer creates a consumer serving requests from queue, which is declared unless opts say otherwise.
+// module is reported as the GError module name of failures.
+func NewServer[Req any, Resp any](url string, queue string, module string, c codec.Codec, handler HandlerFunc[Req, Resp], opts ...consumer.ConsumerOption) *consumer.ReliableRabbitConsumer {
+	opts = append([]consumer.ConsumerOption{consumer.WithDeclaredQueueArgs(consumer.DeclaredQueueArgs{Name: queue})}, opts...)
+	s := consumer.NewReliableRabbitConsumer(url, nil, opts...)
+	s.HandleFunc = NewServeFunc(module, c, handler, func() consumer.ChannelPublisher {
+		if channel := s.Channel(); channel != nil {
+			return channel
+		}
+		return nil
+	})
+	return s
+}
+
+// NewServeFunc adapts a HandlerFunc to a consumer handler. Replies are encoded with c and published on the channel
+// returned by channel, nil while disconnected. Requests are decoded by their content type, c when there is none.
+func NewServeFunc[Req any, Resp any](module string, c codec.Codec, handler HandlerFunc[Req, Resp], channel func() consumer.ChannelPublisher) consumer.HandleFunc {
+	s := &server[Req, Resp]{
+		module:  module,
+		codec:   c,
+		handler: handler,
+		channel: channel,
+	}
+	return s.handle
+}
+
+type server[Req any, Resp any] struct {
+	module  string
+	codec   codec.Codec
+	handler HandlerFunc[Req, Resp]
+	channel func() consumer.ChannelPublisher
+}
+
+func (s *server[Req, Resp]) handle(ctx context.Context, msg amqp091.Delivery) interface{} {
+	logger := log.With().Str("module", s.module).Str("correlationId", msg.CorrelationId).Str("routingKey", msg.RoutingKey).Logger()
+	if d, ok := deadline(msg); ok {
+		if !time.Now().Before(d) {
+			logger.Warn().Time("deadline", d).Msg("rpc request expired before handling, dropping")
+			return msg.Ack(false)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, d)
+		defer cancel()
+	}
+
+	reply := s.serve(ctx, msg)
+	if msg.ReplyTo == "" {
+		logger.Debug().Msg("rpc request without reply address, reply dropped")
+		return msg.Ack(false)
+	}
+	reply.CorrelationId = msg.CorrelationId
+	reply.Timestamp = time.Now()
+
+	err := s.reply(ctx, msg.ReplyTo, reply)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to publish rpc reply, requeueing")
+		return msg.Nack(false, true)
+	}
+	return msg.Ack(false)
+}
+
+func (s *server[Req, Resp]) serve(ctx context.Context, msg amqp091.Delivery) amqp091.Publishing {
+	req, err := codec.DecodeAs[Req](msg, s.codec)
+	if err != nil {
+		return errorPublishing(grpcserver.NewGError(s.module, berror.ErrBadRequest, "bad request", err.Error(), "", nil, grpcserver.Category_Business))
+	}
+	resp, err := s.handler(ctx, req)
+	if err != nil {
+		return errorPublishing(ToGError(s.module, err))
+	}
+	p, err := codec.Encode(s.codec, resp, "", amqp091.Publishing{})
+	if err != nil {
+		return errorPublishing(ToGError(s.module, fmt.Errorf("failed to encode reply: %w", err)))
+	}
+	p.Headers = amqp091.Table{HeaderStatus: StatusOK}
+	return p
+}
+
+func (s *server[Req, Resp]) reply(ctx context.Context, replyTo string, p amqp091.Publishing) error {
+	channel := s.channel()
+	if channel == nil {
+		return fmt.Errorf("consumer channel not ready")
+	}
+	// the reply is still wanted when the handler ran out of time: the caller may not have given up yet
+	return channel.PublishWithContext(context.WithoutCancel(ctx), "", replyTo, false, false, p)
+}
@@ -0,0 +1,166 @@
+package topology
+
+import (
+	"fmt"
+	"github.com/rabbitmq/amqp091-go"
+	"time"
+)
+
+// Declarer is the subset of *amqp091.Channel needed to declare a topology.
+type Declarer interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp091.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp091.Table) (amqp091.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp091.Table) error
+	ExchangeBind(destination, key, source string, noWait bool, args amqp091.Table) error
+}
+
+type Exchange struct {
+	Name       string        `mapstructure:"name"`
+	Kind       string        `mapstructure:"kind"`
+	Durable    bool          `mapstructure:"durable"`
+	AutoDelete bool          `mapstructure:"auto_delete"`
+	Internal   bool          `mapstructure:"internal"`
+	NoWait     bool          `mapstructure:"no_wait"`
+	Args       amqp091.Table `mapstructure:"args"`
+	// AlternateExchange receives messages that cannot be routed by this exchange.
+	AlternateExchange string `mapstructure:"alternate_exchange"`
+}
+
+type Queue struct {
+	Name       string        `mapstructure:"name"`
+	Durable    bool          `mapstructure:"durable"`
+	AutoDelete bool          `mapstructure:"auto_delete"`
+	Exclusive  bool          `mapstructure:"exclusive"`
+	NoWait     bool          `mapstructure:"no_wait"`
+	Args       amqp091.Table `mapstructure:"args"`
+	// DeadLetterExchange receives rejected and expired messages. Empty string disables dead-lettering.
+	DeadLetterExchange string `mapstructure:"dead_letter_exchange"`
+	// DeadLetterRoutingKey replaces the routing key of dead-lettered messages when set.
+	DeadLetterRoutingKey string `mapstructure:"dead_letter_routing_key"`
+	// MessageTTL expires messages after staying this long in the queue. 0 means no TTL.
+	MessageTTL time.Duration `mapstructure:"message_ttl"`
+	// Expires deletes the queue after being unused for this long. 0 means never.
+	Expires time.Duration `mapstructure:"expires"`
+	// MaxLength caps the number of ready messages. 0 means unlimited.
+	MaxLength int `mapstructure:"max_length"`
+	// QueueType is the x-queue-type argument, e.g. "quorum". Empty means classic.
+	QueueType string `mapstructure:"queue_type"`
+}
+
+// Binding binds a queue to an exchange.
+type Binding struct {
+	Queue      string        `mapstructure:"queue"`
+	Exchange   string        `mapstructure:"exchange"`
+	RoutingKey string        `mapstructure:"routing_key"`
+	NoWait     bool          `mapstructure:"no_wait"`
+	Args       amqp091.Table `mapstructure:"args"`
+}
+
+// ExchangeBinding binds the Destination exchange to the Source exchange.
+type ExchangeBinding struct {
+	Destination string        `mapstructure:"destination"`
+	Source      string        `mapstructure:"source"`
+	RoutingKey  string        `mapstructure:"routing_key"`
+	NoWait      bool          `mapstructure:"no_wait"`
+	Args        amqp091.Table `mapstructure:"args"`
+}
+
+// Topology is a declarative description of exchanges, queues and bindings.
+// It is applied on every (re)connect by both publisher and consumer, so every declaration must be idempotent.
+type Topology struct {
+	Exchanges        []Exchange        `mapstructure:"exchanges"`
+	Queues           []Queue           `mapstructure:"queues"`
+	Bindings         []Binding         `mapstructure:"bindings"`
+	ExchangeBindings []ExchangeBinding `mapstructure:"exchange_bindings"`
+}
+
+// Merge returns a topology containing the declarations of t followed by those of other.
+func (t Topology) Merge(other Topology) Topology {
+	return Topology{
+		Exchanges:        append(append([]Exchange{}, t.Exchanges...), other.Exchanges...),
+		Queues:           append(append([]Queue{}, t.Queues...), other.Queues...),
+		Bindings:         append(append([]Binding{}, t.Bindings...), other.Bindings...),
+		ExchangeBindings: append(append([]ExchangeBinding{}, t.ExchangeBindings...), other.ExchangeBindings...),
+	}
+}
+
+func (t Topology) IsEmpty() bool {
+	return len(t.Exchanges) == 0 && len(t.Queues) == 0 && len(t.Bindings) == 0 && len(t.ExchangeBindings) == 0
+}
+
+// Declare declares exchanges, then queues, then queue bindings, then exchange bindings.
+func (t Topology) Declare(ch Declarer) (err error) {
+	for _, e := range t.Exchanges {
+		err = ch.ExchangeDeclare(e.Name, e.Kind, e.Durable, e.AutoDelete, e.Internal, e.NoWait, e.Arguments())
+		if err != nil {
+			return fmt.Errorf("declare exchange %s: %w", e.Name, err)
+		}
+	}
+	for _, q := range t.Queues {
+		_, err = ch.QueueDeclare(q.Name, q.Durable, q.AutoDelete, q.Exclusive, q.NoWait, q.Arguments())
+		if err != nil {
+			return fmt.Errorf("declare queue %s: %w", q.Name, err)
+		}
+	}
+	for _, b := range t.Bindings {
+		err = ch.QueueBind(b.Queue, b.RoutingKey, b.Exchange, b.NoWait, b.Args)
+		if err != nil {
+			return fmt.Errorf("bind queue %s to exchange %s: %w", b.Queue, b.Exchange, err)
+		}
+	}
+	for _, b := range t.ExchangeBindings {
+		err = ch.ExchangeBind(b.Destination, b.RoutingKey, b.Source, b.NoWait, b.Args)
+		if err != nil {
+			return fmt.Errorf("bind exchange %s to exchange %s: %w", b.Destination, b.Source, err)
+		}
+	}
+	return nil
+}
+
+// Arguments returns Args merged with the arguments derived from the typed fields.
+func (e Exchange) Arguments() amqp091.Table {
+	args := copyTable(e.Args)
+	if e.AlternateExchange != "" {
+		args["alternate-exchange"] = e.AlternateExchange
+	}
+	return nilIfEmpty(args)
+}
+
+// Arguments returns Args merged with the arguments derived from the typed fields.
+func (q Queue) Arguments() amqp091.Table {
+	args := copyTable(q.Args)
+	if q.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = q.DeadLetterExchange
+	}
+	if q.DeadLetterRoutingKey != "" {
+		args["x-dead-letter-routing-key"] = q.DeadLetterRoutingKey
+	}
+	if q.MessageTTL > 0 {
+		args["x-message-ttl"] = q.MessageTTL.Milliseconds()
+	}
+	if q.Expires > 0 {
+		args["x-expires"] = q.Expires.Milliseconds()
+	}
+	if q.MaxLength > 0 {
+		args["x-max-length"] = int64(q.MaxLength)
+	}
+	if q.QueueType != "" {
+		args["x-queue-type"] = q.QueueType
+	}
+	return nilIfEmpty(args)
+}
+
+func copyTable(t amqp091.Table) amqp091.Table {
+	c := amqp091.Table{}
+	for k, v := range t {
+		c[k] = v
+	}
+	return c
+}
+
+func nilIfEmpty(t amqp091.Table) amqp091.Table {
+	if len(t) == 0 {
+		return nil
+	}
+	return t
+}
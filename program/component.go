@@ -1,6 +1,7 @@
 package program
 
 import (
+	"fmt"
 	"github.com/rs/zerolog/log"
 	"strings"
 )
@@ -12,6 +13,44 @@ type Component interface {
 	Name() string
 }
 
+// StarterE is optionally implemented by components whose start can fail. Engines call StartE instead of Start and
+// stop the components started before it when it fails.
+type StarterE interface {
+	StartE() error
+}
+
+// StartComponent starts component with StartE if it implements StarterE, else with Start.
+func StartComponent(component Component) error {
+	if starter, ok := component.(StarterE); ok {
+		return starter.StartE()
+	}
+	component.Start()
+	return nil
+}
+
+// HealthChecker is optionally implemented by components that can report readiness.
+// Healthy returns nil when the component is ready to serve.
+type HealthChecker interface {
+	Healthy() error
+}
+
+// Configurable is optionally implemented by components that read their config from the engine Config.
+// Configure is called before Start; an error is fatal.
+type Configurable interface {
+	Configure(cfg *Config) error
+}
+
+// CheckHealth returns the health of every component implementing HealthChecker, keyed by component name.
+func CheckHealth(components []Component) map[string]error {
+	result := make(map[string]error)
+	for _, component := range components {
+		if checker, ok := component.(HealthChecker); ok {
+			result[component.Name()] = checker.Healthy()
+		}
+	}
+	return result
+}
+
 type ComponentProvider interface {
 	ProvideAllComponents() []Component
 	ProvideDisabledComponents() map[string]bool
@@ -44,22 +83,47 @@ func (n *ComponentService) AddComponent(component Component) {
 	n.components = append(n.components, component)
 }
 
+// Start is StartE exiting the program when a component fails to start.
 func (n *ComponentService) Start() {
-	for _, component := range n.components {
+	err := n.StartE()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to start components")
+	}
+}
+
+// StartE starts the components in order. When one fails, the ones started before it are stopped and the error is
+// returned.
+func (n *ComponentService) StartE() error {
+	for i, component := range n.components {
 		log.Info().Str("name", component.Name()).Msg("starting component")
-		component.Start()
+		err := StartComponent(component)
+		if err != nil {
+			log.Error().Err(err).Str("name", component.Name()).Msg("failed to start component")
+			stopComponents(n.components[:i])
+			return fmt.Errorf("%s: %w", component.Name(), err)
+		}
 		log.Info().Str("name", component.Name()).Msg("started component")
 	}
 	log.Info().Msg("all components started")
+	return nil
 }
 
 func (n *ComponentService) Stop() {
-	for i := len(n.components) - 1; i >= 0; i-- {
-		comp := n.components[i]
+	stopComponents(n.components)
+	log.Info().Msg("all components stopped")
+}
+
+// stopComponents stops components in reverse order.
+func stopComponents(components []Component) {
+	for i := len(components) - 1; i >= 0; i-- {
+		comp := components[i]
 		log.Info().Str("name", comp.Name()).Msg("stopping component")
 
 		comp.Stop()
 		log.Info().Str("name", comp.Name()).Msg("stopped component")
 	}
-	log.Info().Msg("all components stopped")
+}
+
+func (n *ComponentService) Health() map[string]error {
+	return CheckHealth(n.components)
 }
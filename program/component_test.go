@@ -0,0 +1,50 @@
+package program
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testComponent struct {
+	name string
+	err  error
+	log  *[]string
+}
+
+func (c *testComponent) StartE() error {
+	*c.log = append(*c.log, "start "+c.name)
+	return c.err
+}
+
+func (c *testComponent) Start() {
+	_ = c.StartE()
+}
+
+func (c *testComponent) Stop() {
+	*c.log = append(*c.log, "stop "+c.name)
+}
+
+func (c *testComponent) Name() string {
+	return c.name
+}
+
+func TestComponentServiceStartFailure(t *testing.T) {
+	var log []string
+	failure := errors.New("broker unreachable")
+	n := &ComponentService{}
+	n.InitComponents()
+	n.AddComponent(&testComponent{name: "db", log: &log})
+	n.AddComponent(&testComponent{name: "cache", log: &log})
+	n.AddComponent(&testComponent{name: "mq", err: failure, log: &log})
+	n.AddComponent(&testComponent{name: "api", log: &log})
+
+	err := n.StartE()
+	if !errors.Is(err, failure) {
+		t.Fatalf("start: %v, want the failure of mq", err)
+	}
+	want := []string{"start db", "start cache", "start mq", "stop cache", "stop db"}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("lifecycle = %v, want %v", log, want)
+	}
+}
@@ -0,0 +1,419 @@
+package program
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config is a loaded configuration with its own viper instance, so several engines or test cases in one process do
+// not share settings. Sub returns a view of a section that shares the loaded state.
+//
+// The package level functions such as LoadConfigs, BindConfig and DumpConfig work on GlobalConfig, which is backed
+// by the global viper until it is reloaded, see ConfigReloader.
+type Config struct {
+	state  *configState
+	prefix string
+}
+
+type configState struct {
+	mu          sync.RWMutex
+	v           *viper.Viper
+	global      bool
+	folders     FolderConfig
+	envPrefix   string
+	profile     string
+	fileSources map[string]string
+	secretKeys  map[string]bool
+	providers   map[string]SecretProvider
+	// flags and defaults are re-applied to a reloaded viper
+	flags    map[string]*pflag.Flag
+	defaults map[string]interface{}
+}
+
+func newConfigState(v *viper.Viper, global bool) *configState {
+	return &configState{
+		v:           v,
+		global:      global,
+		fileSources: make(map[string]string),
+		secretKeys:  make(map[string]bool),
+		providers:   make(map[string]SecretProvider),
+		flags:       make(map[string]*pflag.Flag),
+		defaults:    make(map[string]interface{}),
+	}
+}
+
+var globalConfig = &Config{state: newConfigState(nil, true)}
+
+// GlobalConfig is the config of the global viper, loaded by LoadConfigs.
+func GlobalConfig() *Config {
+	return globalConfig
+}
+
+// NewConfig returns an empty config with its own viper.
+func NewConfig() *Config {
+	return &Config{state: newConfigState(viper.New(), false)}
+}
+
+// LoadConfig loads the config layers of LoadConfigsWithProfile into a new Config instead of the global viper.
+// It does not dump the config.
+func LoadConfig(folderConfig FolderConfig, envPrefix string, profile string) (*Config, error) {
+	c := NewConfig()
+	err := c.load(folderConfig, envPrefix, profile)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Viper returns the viper instance holding the whole config. It is replaced by a reload, so do not keep it.
+func (c *Config) Viper() *viper.Viper {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+	if c.state.v == nil {
+		return viper.GetViper()
+	}
+	return c.state.v
+}
+
+// Sub returns the section key as a Config. Keys of the section are relative to it.
+func (c *Config) Sub(key string) *Config {
+	return &Config{state: c.state, prefix: c.Key(key)}
+}
+
+// Key returns the full key of key in the whole config.
+func (c *Config) Key(key string) string {
+	if key == "" {
+		return c.prefix
+	}
+	return strings.ToLower(joinKey(c.prefix, key))
+}
+
+// Folders returns the folders the config was loaded from.
+func (c *Config) Folders() FolderConfig {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+	return c.state.folders
+}
+
+// Profile returns the profile the config was loaded with.
+func (c *Config) Profile() string {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+	return c.state.profile
+}
+
+func (c *Config) EnvPrefix() string {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+	return c.state.envPrefix
+}
+
+func (c *Config) Get(key string) interface{} {
+	return c.Viper().Get(c.Key(key))
+}
+
+func (c *Config) GetString(key string) string {
+	return c.Viper().GetString(c.Key(key))
+}
+
+func (c *Config) GetBool(key string) bool {
+	return c.Viper().GetBool(c.Key(key))
+}
+
+func (c *Config) GetInt(key string) int {
+	return c.Viper().GetInt(c.Key(key))
+}
+
+func (c *Config) GetInt64(key string) int64 {
+	return c.Viper().GetInt64(c.Key(key))
+}
+
+func (c *Config) GetFloat64(key string) float64 {
+	return c.Viper().GetFloat64(c.Key(key))
+}
+
+func (c *Config) GetDuration(key string) time.Duration {
+	return c.Viper().GetDuration(c.Key(key))
+}
+
+func (c *Config) GetStringSlice(key string) []string {
+	return c.Viper().GetStringSlice(c.Key(key))
+}
+
+func (c *Config) GetStringMap(key string) map[string]interface{} {
+	return c.Viper().GetStringMap(c.Key(key))
+}
+
+func (c *Config) IsSet(key string) bool {
+	return c.Viper().IsSet(c.Key(key))
+}
+
+// Set overrides key, above files and env. Meant for tests.
+func (c *Config) Set(key string, value interface{}) {
+	c.Viper().Set(c.Key(key), value)
+}
+
+// BindFlag makes flag override key when it is given on the command line, like viper.BindPFlag. The binding is kept
+// across reloads.
+func (c *Config) BindFlag(key string, flag *pflag.Flag) error {
+	if flag == nil {
+		return fmt.Errorf("config %s: no flag to bind", c.Key(key))
+	}
+	key = c.Key(key)
+	err := c.Viper().BindPFlag(key, flag)
+	if err != nil {
+		return err
+	}
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	c.state.flags[key] = flag
+	return nil
+}
+
+// SetDefault sets the value of key when nothing else does, like viper.SetDefault. The default is kept across
+// reloads.
+func (c *Config) SetDefault(key string, value interface{}) {
+	key = c.Key(key)
+	c.Viper().SetDefault(key, value)
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	c.state.defaults[key] = value
+}
+
+// AllKeys lists the keys of the section, relative to it.
+func (c *Config) AllKeys() []string {
+	keys := c.Viper().AllKeys()
+	if c.prefix == "" {
+		sort.Strings(keys)
+		return keys
+	}
+	prefix := c.prefix + "."
+	var result []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			result = append(result, strings.TrimPrefix(key, prefix))
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// AllSettings returns the section as nested maps.
+func (c *Config) AllSettings() map[string]interface{} {
+	if c.prefix == "" {
+		return c.Viper().AllSettings()
+	}
+	return c.Viper().GetStringMap(c.prefix)
+}
+
+// Bind binds the section key, or the whole section when key is empty, see BindConfig.
+func (c *Config) Bind(key string, out interface{}) error {
+	return bindConfig(c.Viper(), c.Key(key), out, c.rootSource)
+}
+
+// BindAll binds several sections, keyed by section relative to c, see BindConfigs.
+func (c *Config) BindAll(targets map[string]interface{}) error {
+	if c.prefix == "" {
+		return bindConfigs(c.Viper(), targets, c.rootSource)
+	}
+	full := make(map[string]interface{}, len(targets))
+	for key, out := range targets {
+		full[c.Key(key)] = out
+	}
+	return bindConfigs(c.Viper(), full, c.rootSource)
+}
+
+// Source tells where the effective value of key comes from, see ConfigSource.
+func (c *Config) Source(key string) string {
+	return c.rootSource(c.Key(key))
+}
+
+func (c *Config) rootSource(key string) string {
+	s := c.state
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key = strings.ToLower(key)
+	if flag, ok := s.flags[key]; ok && flag.Changed {
+		return SourceFlag + ":--" + flag.Name
+	}
+	if s.envPrefix != "" {
+		if _, ok := os.LookupEnv(EnvKey(s.envPrefix, key)); ok {
+			return SourceEnv + ":" + EnvKey(s.envPrefix, key)
+		}
+	}
+	if file, ok := s.fileSources[key]; ok {
+		return file
+	}
+	// a section is as good as its last file
+	prefix := key + "."
+	for k, file := range s.fileSources {
+		if strings.HasPrefix(k, prefix) {
+			return file
+		}
+	}
+	return ""
+}
+
+func (c *Config) isSecret(key string) bool {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+	return c.state.secretKeys[strings.ToLower(key)]
+}
+
+// recordFileSource remembers that the keys were last set by file.
+func (c *Config) recordFileSource(file string, keys []string) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	for _, key := range keys {
+		c.state.fileSources[strings.ToLower(key)] = file
+	}
+}
+
+func (c *Config) load(folderConfig FolderConfig, envPrefix string, profile string) error {
+	// init logger first.
+	folders, err := EnsureFoldersE(folderConfig)
+	if err != nil {
+		return err
+	}
+	c.state.mu.Lock()
+	c.state.folders = folders
+	c.state.profile = profile
+	c.state.mu.Unlock()
+	c.RegisterSecretProvider(EncSecretProvider{KeyFile: path.Join(folders.Private, SecretKeyFile)})
+
+	// unresolvable secrets are collected over all files, other problems stop the load
+	var secretErrs ConfigErrors
+	for _, file := range configFiles(folders, profile) {
+		err = c.mergeFileIfExists(file)
+		var configErrors ConfigErrors
+		if errors.As(err, &configErrors) {
+			secretErrs = append(secretErrs, configErrors...)
+		} else if err != nil {
+			return err
+		}
+	}
+	c.readEnv(envPrefix)
+	if len(secretErrs) > 0 {
+		return secretErrs
+	}
+	return nil
+}
+
+func (c *Config) mergeLayer(folder string, name string) error {
+	for _, configPath := range layerFiles(folder, name) {
+		err := c.mergeFileIfExists(configPath)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) mergeFileIfExists(configPath string) error {
+	exists, err := configFileExists(configPath)
+	if err != nil || !exists {
+		return err
+	}
+	return c.MergeFile(configPath)
+}
+
+// MergeFile merges one config file over the config, returning a *ConfigFileError on failure. Secret references in
+// the file are resolved, see SecretProvider; the ones that cannot be resolved are returned as ConfigErrors after the
+// rest of the file is merged.
+func (c *Config) MergeFile(configPath string) error {
+	absPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return &ConfigFileError{Path: configPath, Err: err}
+	}
+
+	// parse alone first to learn which keys the file sets
+	fileViper, err := readConfigFile(absPath)
+	if err != nil {
+		return err
+	}
+
+	settings := fileViper.AllSettings()
+	secretKeys, secretErrs := c.resolveSettings(absPath, settings)
+	err = c.Viper().MergeConfigMap(settings)
+	if err != nil {
+		return &ConfigFileError{Path: absPath, Err: err}
+	}
+	c.recordFileSource(absPath, fileViper.AllKeys())
+	c.recordSecrets(secretKeys)
+	if len(secretErrs) > 0 {
+		return secretErrs
+	}
+	return nil
+}
+
+func (c *Config) readEnv(envPrefix string) {
+	v := c.Viper()
+	v.SetEnvPrefix(envPrefix)
+	v.AutomaticEnv()
+	c.state.mu.Lock()
+	c.state.envPrefix = envPrefix
+	c.state.mu.Unlock()
+}
+
+// RegisterSecretProvider adds a provider to this config only, taking precedence over RegisterSecretProvider.
+// On GlobalConfig it is RegisterSecretProvider.
+func (c *Config) RegisterSecretProvider(provider SecretProvider) {
+	if c.state.global {
+		RegisterSecretProvider(provider)
+		return
+	}
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	c.state.providers[provider.Scheme()] = provider
+}
+
+// NewReloader reloads the folders and profile the config was loaded with, see ConfigReloader.
+func (c *Config) NewReloader(opts ...ReloadOption) *ConfigReloader {
+	r := &ConfigReloader{
+		config:    c,
+		folders:   c.Folders(),
+		envPrefix: c.EnvPrefix(),
+		profile:   c.Profile(),
+		subs:      make(map[int]*configSubscription),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// bindings returns the flags and defaults to re-apply on reload.
+func (c *Config) bindings() (map[string]*pflag.Flag, map[string]interface{}) {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+	flags := make(map[string]*pflag.Flag, len(c.state.flags))
+	for key, flag := range c.state.flags {
+		flags[key] = flag
+	}
+	defaults := make(map[string]interface{}, len(c.state.defaults))
+	for key, value := range c.state.defaults {
+		defaults[key] = value
+	}
+	return flags, defaults
+}
+
+// replace swaps in the viper, sources and secrets of a reloaded candidate.
+func (c *Config) replace(candidate *Config) {
+	next := candidate.state
+	next.mu.RLock()
+	defer next.mu.RUnlock()
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	c.state.v = next.v
+	c.state.fileSources = next.fileSources
+	c.state.secretKeys = next.secretKeys
+}
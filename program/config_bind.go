@@ -0,0 +1,315 @@
+package program
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigError is one missing or invalid config key.
+type ConfigError struct {
+	Key     string
+	Source  string
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	source := e.Source
+	if source == "" {
+		source = "not set"
+	}
+	return fmt.Sprintf("%s: %s (%s)", e.Key, e.Message, source)
+}
+
+// ConfigErrors lists every problem found while binding, so they can be fixed in one go.
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Error() string {
+	var sb strings.Builder
+	sb.WriteString("invalid configuration:")
+	for _, configError := range e {
+		sb.WriteString("\n  ")
+		sb.WriteString(configError.Error())
+	}
+	return sb.String()
+}
+
+// ConfigValidator is implemented by config structs with checks the validate tags cannot express.
+type ConfigValidator interface {
+	Validate() error
+}
+
+var configValidate = newConfigValidate()
+
+func newConfigValidate() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(configKeyName)
+	return v
+}
+
+// configKeyName is the viper key of a struct field, following mapstructure.
+func configKeyName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+	if name == "" || name == "-" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// BindConfig reads the viper section key, or the whole config when key is empty, into out, a pointer to a struct.
+//
+// Keys are named by `mapstructure` tags. A `default` tag sets the value of keys that are not configured,
+// e.g. `default:"30s"`, slices take comma separated values. `validate` tags are checked with
+// github.com/go-playground/validator, e.g. `validate:"required,url"`, `validate:"min=1,max=64"`,
+// `validate:"oneof=debug info warn"` or `validate:"min=1s"` on a time.Duration. Then out.Validate is called if out
+// implements ConfigValidator. All problems are returned together as ConfigErrors.
+func BindConfig(key string, out interface{}) error {
+	return globalConfig.Bind(key, out)
+}
+
+// BindConfigFrom is BindConfig reading from v instead of the global viper.
+func BindConfigFrom(v *viper.Viper, key string, out interface{}) error {
+	return bindConfig(v, key, out, viperSource(v))
+}
+
+// viperSource names the config file of v as the source of its keys, see ConfigSource.
+func viperSource(v *viper.Viper) func(key string) string {
+	if v == globalConfig.Viper() {
+		return globalConfig.rootSource
+	}
+	return func(key string) string {
+		if v.InConfig(key) {
+			return v.ConfigFileUsed()
+		}
+		return ""
+	}
+}
+
+// bindConfig binds key of v, naming the source of problems with source.
+func bindConfig(v *viper.Viper, key string, out interface{}, source func(key string) string) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config %s: expected a pointer to a struct, got %T", key, out)
+	}
+
+	errs := applyDefaults(key, rv.Elem())
+	err := sectionViper(v, key, rv.Elem().Type()).Unmarshal(out)
+	if err != nil {
+		return append(errs, ConfigError{Key: key, Source: source(key), Message: err.Error()})
+	}
+
+	errs = append(errs, validateConfig(key, rv.Elem().Type().Name(), out, source)...)
+	if v, ok := out.(ConfigValidator); ok {
+		if err = v.Validate(); err != nil {
+			errs = append(errs, ConfigError{Key: key, Source: source(key), Message: err.Error()})
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// BindConfigs binds several sections, keyed by section, and reports the problems of all of them.
+func BindConfigs(targets map[string]interface{}) error {
+	return globalConfig.BindAll(targets)
+}
+
+// BindConfigsFrom is BindConfigs reading from v instead of the global viper.
+func BindConfigsFrom(v *viper.Viper, targets map[string]interface{}) error {
+	return bindConfigs(v, targets, viperSource(v))
+}
+
+// bindConfigs binds the targets of v, naming the source of problems with source.
+func bindConfigs(v *viper.Viper, targets map[string]interface{}, source func(key string) string) error {
+	keys := make([]string, 0, len(targets))
+	for key := range targets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs ConfigErrors
+	for _, key := range keys {
+		err := bindConfig(v, key, targets[key], source)
+		var configErrors ConfigErrors
+		switch {
+		case err == nil:
+		case errors.As(err, &configErrors):
+			errs = append(errs, configErrors...)
+		default:
+			errs = append(errs, ConfigError{Key: key, Message: err.Error()})
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// sectionViper copies the section key into a new viper. viper.UnmarshalKey ignores environment variables of
+// nested keys, so the keys of the struct are looked up one by one on top of the section.
+func sectionViper(from *viper.Viper, key string, t reflect.Type) *viper.Viper {
+	v := viper.New()
+	if key == "" {
+		_ = v.MergeConfigMap(from.AllSettings())
+	} else if from.IsSet(key) {
+		_ = v.MergeConfigMap(from.GetStringMap(key))
+	}
+	for _, leaf := range leafKeys("", t) {
+		if from.IsSet(joinKey(key, leaf)) {
+			v.Set(leaf, from.Get(joinKey(key, leaf)))
+		}
+	}
+	return v
+}
+
+// leafKeys lists the keys of the non-struct fields of t.
+func leafKeys(prefix string, t reflect.Type) (keys []string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fieldKey := joinKey(prefix, configKeyName(field))
+		if field.Type.Kind() == reflect.Struct && field.Type != timeType {
+			keys = append(keys, leafKeys(fieldKey, field.Type)...)
+		} else {
+			keys = append(keys, fieldKey)
+		}
+	}
+	return
+}
+
+func joinKey(prefix string, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// applyDefaults sets zero fields that have a default tag, recursing into nested structs.
+func applyDefaults(key string, v reflect.Value) (errs ConfigErrors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		fieldKey := joinKey(key, configKeyName(field))
+		if def, ok := field.Tag.Lookup("default"); ok && fv.IsZero() {
+			if err := setFromString(fv, def); err != nil {
+				errs = append(errs, ConfigError{Key: fieldKey, Source: SourceDefault, Message: err.Error()})
+			}
+			continue
+		}
+		if fv.Kind() == reflect.Struct && fv.Type() != timeType {
+			errs = append(errs, applyDefaults(fieldKey, fv)...)
+		}
+	}
+	return
+}
+
+func setFromString(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		parts := strings.Split(s, ",")
+		slice := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setFromString(slice.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("default tag not supported for %s", v.Type())
+	}
+	return nil
+}
+
+func validateConfig(key string, typeName string, out interface{}, source func(key string) string) (errs ConfigErrors) {
+	err := configValidate.Struct(out)
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		if err != nil {
+			errs = append(errs, ConfigError{Key: key, Message: err.Error()})
+		}
+		return
+	}
+	for _, fe := range validationErrors {
+		// the namespace starts with the struct type name
+		path := strings.TrimPrefix(fe.Namespace(), typeName+".")
+		fieldKey := joinKey(key, path)
+		errs = append(errs, ConfigError{Key: fieldKey, Source: source(fieldKey), Message: describe(fe)})
+	}
+	return
+}
+
+// describe explains a failed rule without echoing the value, which may be a secret.
+func describe(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min", "gte":
+		return "must be at least " + fe.Param()
+	case "max", "lte":
+		return "must be at most " + fe.Param()
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "lt":
+		return "must be less than " + fe.Param()
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(fe.Param()), ", ")
+	case "url":
+		return "must be a URL"
+	}
+	if fe.Param() != "" {
+		return fmt.Sprintf("failed %s=%s", fe.Tag(), fe.Param())
+	}
+	return "failed " + fe.Tag()
+}
@@ -0,0 +1,101 @@
+package program
+
+import (
+	"errors"
+	"github.com/spf13/viper"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testServerConfig struct {
+	URL     string        `mapstructure:"url" validate:"required,url"`
+	Timeout time.Duration `mapstructure:"timeout" default:"30s" validate:"min=1s"`
+	Workers int           `mapstructure:"workers" default:"4" validate:"min=1,max=64"`
+	Tags    []string      `mapstructure:"tags" default:"a,b"`
+}
+
+func TestBindDefaultsAndEnv(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "config"), "config.toml", "[server]\nurl = \"http://localhost\"\nworkers = 2\n")
+	t.Setenv(EnvKey("BINDTEST", "server.workers"), "8")
+
+	c, err := LoadConfig(testFolders(root), "BINDTEST", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out testServerConfig
+	if err = c.Bind("server", &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.URL != "http://localhost" || out.Timeout != 30*time.Second || out.Workers != 8 {
+		t.Errorf("bound %+v, want the file url, the default timeout and the env workers", out)
+	}
+	if len(out.Tags) != 2 || out.Tags[0] != "a" || out.Tags[1] != "b" {
+		t.Errorf("tags = %v, want the default [a b]", out.Tags)
+	}
+
+	var sub testServerConfig
+	if err = c.Sub("server").Bind("", &sub); err != nil {
+		t.Fatal(err)
+	}
+	if sub.URL != out.URL || sub.Timeout != out.Timeout || sub.Workers != out.Workers {
+		t.Errorf("section bound %+v, want %+v", sub, out)
+	}
+}
+
+func TestBindValidationErrors(t *testing.T) {
+	root := t.TempDir()
+	file := writeTestFile(t, filepath.Join(root, "config"), "config.toml",
+		"[server]\nurl = \"not a url\"\nworkers = 100\n[other]\nurl = \"http://localhost\"\ntimeout = \"1ms\"\n")
+
+	c, err := LoadConfig(testFolders(root), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.BindAll(map[string]interface{}{
+		"server": &testServerConfig{},
+		"other":  &testServerConfig{},
+	})
+	var configErrors ConfigErrors
+	if !errors.As(err, &configErrors) {
+		t.Fatalf("bind invalid config: %v, want ConfigErrors", err)
+	}
+	want := map[string]bool{"other.timeout": true, "server.url": true, "server.workers": true}
+	if len(configErrors) != len(want) {
+		t.Fatalf("errors = %v, want one for each of %v", configErrors, want)
+	}
+	for _, configError := range configErrors {
+		if !want[configError.Key] {
+			t.Errorf("unexpected error %v", configError)
+		}
+		if configError.Source != file {
+			t.Errorf("error of %s names %q, want %s", configError.Key, configError.Source, file)
+		}
+	}
+}
+
+func TestBindConfigsFromNamesItsFile(t *testing.T) {
+	file := writeTestFile(t, t.TempDir(), "other.toml", "[server]\nurl = \"not a url\"\n")
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := BindConfigsFrom(v, map[string]interface{}{"server": &testServerConfig{}})
+	var configErrors ConfigErrors
+	if !errors.As(err, &configErrors) || len(configErrors) != 1 {
+		t.Fatalf("bind invalid config: %v, want one ConfigError", err)
+	}
+	if configErrors[0].Key != "server.url" || configErrors[0].Source != file {
+		t.Errorf("error names %s in %q, want server.url in %s", configErrors[0].Key, configErrors[0].Source, file)
+	}
+}
+
+func TestBindRejectsNonStruct(t *testing.T) {
+	var n int
+	if err := NewConfig().Bind("server", &n); err == nil {
+		t.Fatal("bound a config into an int")
+	}
+}
@@ -1,39 +1,114 @@
 package program
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/latifrons/commongo/files"
-	"github.com/latifrons/commongo/format"
 	"github.com/latifrons/commongo/utilfuncs"
 	"github.com/spf13/viper"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 )
 
+// ConfigExtensions are the supported config formats. When a config file exists in several formats,
+// they are merged in this order, so a key in config.yaml overrides the same key in config.toml and config.json
+// overrides both.
+var ConfigExtensions = []string{".toml", ".yaml", ".yml", ".json"}
+
+// ActiveProfile is the profile the config was loaded with, empty if none.
+func ActiveProfile() string {
+	return globalConfig.Profile()
+}
+
+// ProfileFromEnv reads the profile, e.g. dev, staging or prod, from <envPrefix>_PROFILE.
+func ProfileFromEnv(envPrefix string) string {
+	return os.Getenv(EnvKey(envPrefix, "profile"))
+}
+
+// ConfigFileError locates a problem with a config file. Line and Column are 0 when unknown.
+type ConfigFileError struct {
+	Path   string
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *ConfigFileError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("config file %s:%d:%d: %v", e.Path, e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("config file %s: %v", e.Path, e.Err)
+}
+
+func (e *ConfigFileError) Unwrap() error {
+	return e.Err
+}
+
+// layerFiles lists the files of one config layer, e.g. config.toml and config.yaml for name "config".
+func layerFiles(folder string, name string) []string {
+	result := make([]string, 0, len(ConfigExtensions))
+	for _, ext := range ConfigExtensions {
+		result = append(result, path.Join(folder, name+ext))
+	}
+	return result
+}
+
+// configFileExists tells whether an optional config file is there.
+func configFileExists(configPath string) (bool, error) {
+	_, err := os.Stat(configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, &ConfigFileError{Path: configPath, Err: err}
+	}
+	return true, nil
+}
+
 func ReadNormalConfig(configFolder string) {
-	configPath := path.Join(configFolder, "config.toml")
+	err := ReadNormalConfigE(configFolder)
+	utilfuncs.PanicIfError(err, "Error on reading config")
+}
+
+// ReadNormalConfigE reads config.toml, or .yaml/.yml/.json, returning a *ConfigFileError on failure.
+func ReadNormalConfigE(configFolder string) error {
+	return globalConfig.mergeLayer(configFolder, "config")
+}
+
+// ReadProfileConfig reads config.<profile>.toml, or .yaml/.yml/.json, over config.toml.
+func ReadProfileConfig(configFolder string, profile string) {
+	err := ReadProfileConfigE(configFolder, profile)
+	utilfuncs.PanicIfError(err, "Error on reading profile config")
+}
 
-	if files.FileExists(configPath) {
-		MergeLocalConfig(configPath)
+// ReadProfileConfigE is ReadProfileConfig returning a *ConfigFileError on failure.
+func ReadProfileConfigE(configFolder string, profile string) error {
+	if profile == "" {
+		return nil
 	}
+	return globalConfig.mergeLayer(configFolder, "config."+profile)
 }
 
 func ReadEnvConfig(envPrefix string) {
 	// env override
-	viper.SetEnvPrefix(envPrefix)
-	viper.AutomaticEnv()
+	globalConfig.readEnv(envPrefix)
 }
 
 func ReadPrivate(privateFolder string) {
-	configPath := path.Join(privateFolder, "private.toml")
-	if files.FileExists(configPath) {
-		MergeLocalConfig(configPath)
-	}
-	configOverridePath := path.Join(privateFolder, "override.toml")
-	if files.FileExists(configOverridePath) {
-		MergeLocalConfig(configOverridePath)
+	err := ReadPrivateE(privateFolder)
+	utilfuncs.PanicIfError(err, "Error on reading private config")
+}
+
+// ReadPrivateE reads private.toml and then override.toml, returning a *ConfigFileError on failure.
+func ReadPrivateE(privateFolder string) error {
+	err := globalConfig.mergeLayer(privateFolder, "private")
+	if err != nil {
+		return err
 	}
+	return globalConfig.mergeLayer(privateFolder, "override")
 }
 
 //func writeConfig() {
@@ -43,33 +118,114 @@ func ReadPrivate(privateFolder string) {
 //}
 
 func MergeLocalConfig(configPath string) {
-	absPath, err := filepath.Abs(configPath)
-	utilfuncs.PanicIfError(err, fmt.Sprintf("Error on parsing config file path: %s", absPath))
+	err := MergeLocalConfigE(configPath)
+	utilfuncs.PanicIfError(err, fmt.Sprintf("Error on reading config file: %s", configPath))
+}
+
+// MergeLocalConfigE merges one config file into viper, returning a *ConfigFileError on failure.
+func MergeLocalConfigE(configPath string) error {
+	return globalConfig.MergeFile(configPath)
+}
+
+// readConfigFile parses one config file into a new viper. The format follows the file extension.
+func readConfigFile(configPath string) (*viper.Viper, error) {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, &ConfigFileError{Path: configPath, Err: err}
+	}
+	v := viper.New()
+	v.SetConfigType(configType(configPath))
+	err = v.ReadConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, parseError(configPath, content, err)
+	}
+	return v, nil
+}
+
+// parseError adds the position of TOML and JSON syntax errors. YAML errors carry the line in their message.
+func parseError(configPath string, content []byte, err error) *ConfigFileError {
+	var parseErr viper.ConfigParseError
+	if errors.As(err, &parseErr) {
+		err = parseErr.Unwrap()
+	}
+	fileErr := &ConfigFileError{Path: configPath, Err: err}
 
-	file, err := os.Open(absPath)
-	utilfuncs.PanicIfError(err, fmt.Sprintf("Error on opening config file: %s", absPath))
-	defer file.Close()
+	var positioned interface{ Position() (int, int) }
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &positioned):
+		fileErr.Line, fileErr.Column = positioned.Position()
+	case errors.As(err, &syntaxErr):
+		fileErr.Line, fileErr.Column = lineColumn(content, syntaxErr.Offset)
+	}
+	return fileErr
+}
 
-	viper.SetConfigType("toml")
-	err = viper.MergeConfig(file)
-	utilfuncs.PanicIfError(err, fmt.Sprintf("Error on reading config file: %s", absPath))
+func lineColumn(content []byte, offset int64) (line int, column int) {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	before := content[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = int(offset) - (bytes.LastIndexByte(before, '\n') + 1)
 	return
 }
 
-func DumpConfig() {
-	// print running config in console.
-	b, err := format.PrettyJson(viper.AllSettings())
-	utilfuncs.PanicIfError(err, "dump json")
-	fmt.Println(b)
+func configType(configPath string) string {
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".json":
+		return "json"
+	default:
+		return "toml"
+	}
+}
+
+// configFiles lists the possible config files in the order they are merged, later files override earlier ones.
+func configFiles(folders FolderConfig, profile string) []string {
+	result := layerFiles(folders.Config, "config")
+	if profile != "" {
+		result = append(result, layerFiles(folders.Config, "config."+profile)...)
+	}
+	result = append(result, layerFiles(folders.Private, "private")...)
+	return append(result, layerFiles(folders.Private, "override")...)
 }
 
+// LoadConfigs loads the config with the profile given by <envPrefix>_PROFILE, see LoadConfigsWithProfile.
 func LoadConfigs(folderConfig FolderConfig, envPrefix string) (folderConfigActual FolderConfig) {
-	// init logger first.
-	folderConfigActual = EnsureFolders(folderConfig)
+	return LoadConfigsWithProfile(folderConfig, envPrefix, ProfileFromEnv(envPrefix))
+}
+
+// LoadConfigsE is LoadConfigs returning errors instead of panicking. It does not dump the config.
+func LoadConfigsE(folderConfig FolderConfig, envPrefix string) (FolderConfig, error) {
+	return LoadConfigsWithProfileE(folderConfig, envPrefix, ProfileFromEnv(envPrefix))
+}
 
-	ReadNormalConfig(folderConfigActual.Config)
-	ReadPrivate(folderConfigActual.Private)
-	ReadEnvConfig(envPrefix)
+// LoadConfigsWithProfile merges the config layers into the global viper, see GlobalConfig, later layers overriding earlier ones:
+//
+//  1. <Config>/config.toml
+//  2. <Config>/config.<profile>.toml, when profile is not empty
+//  3. <Private>/private.toml
+//  4. <Private>/override.toml
+//  5. environment variables <envPrefix>_<KEY>
+//
+// Every file may also be written as .yaml, .yml or .json. If a layer exists in several formats they are merged
+// in ConfigExtensions order, later formats winning, before the next layer.
+//
+// Values of the files that are secret references such as "file:///run/secrets/db_pw", "env:DB_PASSWORD" or
+// "enc:..." are resolved as the files are read, also inside lists, see SecretProvider. Environment variables are
+// taken literally. "enc:" values are decrypted with <Private>/secret.key.
+func LoadConfigsWithProfile(folderConfig FolderConfig, envPrefix string, profile string) (folderConfigActual FolderConfig) {
+	folderConfigActual, err := LoadConfigsWithProfileE(folderConfig, envPrefix, profile)
+	utilfuncs.PanicIfError(err, "Error on loading configs")
 	DumpConfig()
 	return
 }
+
+// LoadConfigsWithProfileE is LoadConfigsWithProfile returning errors instead of panicking: a *FolderError,
+// a *ConfigFileError or the ConfigErrors of unresolvable secrets. It does not dump the config.
+func LoadConfigsWithProfileE(folderConfig FolderConfig, envPrefix string, profile string) (folderConfigActual FolderConfig, err error) {
+	err = globalConfig.load(folderConfig, envPrefix, profile)
+	return globalConfig.Folders(), err
+}
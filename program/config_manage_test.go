@@ -0,0 +1,73 @@
+package program
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFilePrecedence(t *testing.T) {
+	root := t.TempDir()
+	config := filepath.Join(root, "config")
+	private := filepath.Join(root, "private")
+	// every file sets the keys of its own layer and the ones below, each layer must win over the earlier ones
+	writeTestFile(t, config, "config.toml", "a = \"config.toml\"\nb = \"config.toml\"\nc = \"config.toml\"\nd = \"config.toml\"\ne = \"config.toml\"\nf = \"config.toml\"\n")
+	writeTestFile(t, config, "config.json", `{"b": "config.json", "c": "config.json", "d": "config.json", "e": "config.json", "f": "config.json"}`)
+	writeTestFile(t, config, "config.dev.yaml", "c: config.dev.yaml\nd: config.dev.yaml\ne: config.dev.yaml\nf: config.dev.yaml\n")
+	writeTestFile(t, config, "config.prod.toml", "c = \"config.prod.toml\"\n")
+	writeTestFile(t, private, "private.yml", "d: private.yml\ne: private.yml\nf: private.yml\n")
+	writeTestFile(t, private, "override.toml", "e = \"override.toml\"\nf = \"override.toml\"\n")
+	t.Setenv("PRECEDENCE_F", "env")
+
+	c, err := LoadConfig(testFolders(root), "PRECEDENCE", "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"a": "config.toml",
+		"b": "config.json",
+		"c": "config.dev.yaml",
+		"d": "private.yml",
+		"e": "override.toml",
+		"f": "env",
+	} {
+		if got := c.GetString(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if source := c.Source("c"); filepath.Base(source) != "config.dev.yaml" {
+		t.Errorf("source of c = %q, want config.dev.yaml", source)
+	}
+}
+
+func TestConfigFormatPrecedence(t *testing.T) {
+	root := t.TempDir()
+	config := filepath.Join(root, "config")
+	writeTestFile(t, config, "config.toml", "a = \"toml\"\nb = \"toml\"\nc = \"toml\"\nd = \"toml\"\n")
+	writeTestFile(t, config, "config.yaml", "b: yaml\nc: yaml\nd: yaml\n")
+	writeTestFile(t, config, "config.yml", "c: yml\nd: yml\n")
+	writeTestFile(t, config, "config.json", `{"d": "json"}`)
+
+	c, err := LoadConfig(testFolders(root), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"a": "toml", "b": "yaml", "c": "yml", "d": "json"} {
+		if got := c.GetString(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestConfigFileErrorPosition(t *testing.T) {
+	root := t.TempDir()
+	file := writeTestFile(t, filepath.Join(root, "config"), "config.json", "{\n  \"a\": 1,\n  \"b\" 2\n}\n")
+
+	_, err := LoadConfig(testFolders(root), "", "")
+	fileError, ok := err.(*ConfigFileError)
+	if !ok {
+		t.Fatalf("load of a broken file: %v, want a *ConfigFileError", err)
+	}
+	if fileError.Path != file || fileError.Line != 3 {
+		t.Errorf("error at %s:%d, want %s:3", fileError.Path, fileError.Line, file)
+	}
+}
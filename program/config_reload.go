@@ -0,0 +1,325 @@
+package program
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type ReloadOption func(*ConfigReloader)
+
+// WithWatch reloads when a config file changes. Without it only SIGHUP and Reload trigger a reload.
+func WithWatch(debounce time.Duration) ReloadOption {
+	return func(r *ConfigReloader) {
+		r.watch = true
+		r.debounce = debounce
+	}
+}
+
+// WithProfile reloads the files of profile instead of the profile the config was loaded with.
+func WithProfile(profile string) ReloadOption {
+	return func(r *ConfigReloader) {
+		r.profile = profile
+	}
+}
+
+// WithReloadCheck adds a check the reloaded config must pass before it is applied.
+func WithReloadCheck(check func(candidate *viper.Viper) error) ReloadOption {
+	return func(r *ConfigReloader) {
+		r.checks = append(r.checks, func(candidate *Config) error {
+			return check(candidate.Viper())
+		})
+	}
+}
+
+// WithReloadValidation validates the reloaded config like BindConfigs. The values only give the struct types,
+// e.g. map[string]interface{}{"svc": &SvcConfig{}}; they are not modified.
+func WithReloadValidation(targets map[string]interface{}) ReloadOption {
+	return func(r *ConfigReloader) {
+		r.checks = append(r.checks, func(candidate *Config) error {
+			fresh := make(map[string]interface{}, len(targets))
+			for key, target := range targets {
+				fresh[key] = reflect.New(reflect.TypeOf(target).Elem()).Interface()
+			}
+			return candidate.BindAll(fresh)
+		})
+	}
+}
+
+// ConfigReloader re-reads the config files of a Config on SIGHUP, on Reload and optionally when the files change.
+// A reload is read and checked as a whole and rejected if anything fails, leaving the running config untouched.
+// Otherwise it replaces the config as a whole: keys deleted from the files are gone and values set with
+// Config.Set are lost, while flags bound with Config.BindFlag and defaults set with Config.SetDefault are kept.
+//
+// Reloading GlobalConfig replaces its viper too: code reading the global viper directly, e.g. viper.GetString,
+// keeps the values of before the first reload. Read through GlobalConfig instead.
+type ConfigReloader struct {
+	config    *Config
+	folders   FolderConfig
+	envPrefix string
+	profile   string
+	watch     bool
+	debounce  time.Duration
+	checks    []func(candidate *Config) error
+
+	reloadMu sync.Mutex
+	subsMu   sync.Mutex
+	subs     map[int]*configSubscription
+	nextSub  int
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+var _ Component = (*ConfigReloader)(nil)
+
+type configSubscription struct {
+	keys []string
+	fn   func(changed []string)
+}
+
+func (r *ConfigReloader) Name() string {
+	return "ConfigReloader"
+}
+
+// Start listens for SIGHUP and file changes.
+func (r *ConfigReloader) Start() {
+	r.quit = make(chan struct{})
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var watcher *fsnotify.Watcher
+	if r.watch {
+		var err error
+		watcher, err = r.newWatcher()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to watch config files, reload on SIGHUP only")
+			watcher = nil
+		}
+	}
+
+	r.wg.Add(1)
+	go r.loop(hup, watcher)
+}
+
+func (r *ConfigReloader) Stop() {
+	close(r.quit)
+	r.wg.Wait()
+}
+
+func (r *ConfigReloader) newWatcher() (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// watch the folders, not the files: editors replace files instead of writing them
+	for _, dir := range r.watchedDirs() {
+		err = watcher.Add(dir)
+		if err != nil {
+			_ = watcher.Close()
+			return nil, fmt.Errorf("watch %s: %w", dir, err)
+		}
+	}
+	return watcher, nil
+}
+
+func (r *ConfigReloader) watchedDirs() []string {
+	dirs := []string{}
+	seen := map[string]bool{}
+	for _, file := range configFiles(r.folders, r.profile) {
+		dir := filepath.Dir(file)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func (r *ConfigReloader) isConfigFile(name string) bool {
+	for _, file := range configFiles(r.folders, r.profile) {
+		if filepath.Clean(name) == filepath.Clean(file) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *ConfigReloader) loop(hup chan os.Signal, watcher *fsnotify.Watcher) {
+	defer r.wg.Done()
+	defer signal.Stop(hup)
+
+	var events chan fsnotify.Event
+	var errs chan error
+	if watcher != nil {
+		defer watcher.Close()
+		events = watcher.Events
+		errs = watcher.Errors
+	}
+
+	var pending <-chan time.Time
+	for {
+		select {
+		case <-hup:
+			log.Info().Msg("caught SIGHUP, reloading config")
+			_ = r.Reload()
+		case event := <-events:
+			if r.isConfigFile(event.Name) && !event.Has(fsnotify.Chmod) {
+				pending = time.After(r.debounce)
+			}
+		case err := <-errs:
+			log.Error().Err(err).Msg("config watcher error")
+		case <-pending:
+			pending = nil
+			log.Info().Msg("config files changed, reloading config")
+			_ = r.Reload()
+		case <-r.quit:
+			return
+		}
+	}
+}
+
+// Reload reads and checks the config files and applies them if they are valid. Subscribers of changed keys are
+// notified before it returns.
+func (r *ConfigReloader) Reload() error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	// the candidate is a Config of its own, so checks name the reloaded files as sources
+	candidate := NewConfig()
+	candidate.readEnv(r.envPrefix)
+	flags, defaults := r.config.bindings()
+	for key, value := range defaults {
+		candidate.SetDefault(key, value)
+	}
+	for key, flag := range flags {
+		if err := candidate.BindFlag(key, flag); err != nil {
+			log.Error().Err(err).Msg("rejected config reload")
+			return err
+		}
+	}
+	for _, file := range configFiles(r.folders, r.profile) {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			continue
+		}
+		if absPath, err := filepath.Abs(file); err == nil {
+			file = absPath
+		}
+		fileViper, err := readConfigFile(file)
+		if err != nil {
+			log.Error().Err(err).Str("file", file).Msg("rejected config reload")
+			return err
+		}
+		settings := fileViper.AllSettings()
+		secrets, secretErrs := r.config.resolveSettings(file, settings)
+		if len(secretErrs) > 0 {
+			log.Error().Err(secretErrs).Str("file", file).Msg("rejected config reload")
+			return secretErrs
+		}
+		err = candidate.Viper().MergeConfigMap(settings)
+		if err != nil {
+			log.Error().Err(err).Str("file", file).Msg("rejected config reload")
+			return err
+		}
+		candidate.recordFileSource(file, fileViper.AllKeys())
+		candidate.recordSecrets(secrets)
+	}
+	for _, check := range r.checks {
+		if err := check(candidate); err != nil {
+			log.Error().Err(err).Msg("rejected config reload")
+			return err
+		}
+	}
+
+	before := settingsSnapshot(r.config.Viper())
+	r.config.replace(candidate)
+	changed := changedKeys(before, settingsSnapshot(r.config.Viper()))
+	log.Info().Strs("changed", changed).Msg("config reloaded")
+	if len(changed) > 0 {
+		r.notify(changed)
+	}
+	return nil
+}
+
+// Subscribe calls fn with the changed keys after a reload that changes one of keys, or any key when keys is
+// empty. A key also matches the keys of its section: "mq" matches "mq.orders.url".
+func (r *ConfigReloader) Subscribe(keys []string, fn func(changed []string)) (cancel func()) {
+	r.subsMu.Lock()
+	defer r.subsMu.Unlock()
+	id := r.nextSub
+	r.nextSub++
+	lowered := make([]string, len(keys))
+	for i, key := range keys {
+		lowered[i] = strings.ToLower(key)
+	}
+	r.subs[id] = &configSubscription{keys: lowered, fn: fn}
+	return func() {
+		r.subsMu.Lock()
+		defer r.subsMu.Unlock()
+		delete(r.subs, id)
+	}
+}
+
+func (r *ConfigReloader) notify(changed []string) {
+	r.subsMu.Lock()
+	subs := make([]*configSubscription, 0, len(r.subs))
+	for _, sub := range r.subs {
+		subs = append(subs, sub)
+	}
+	r.subsMu.Unlock()
+
+	for _, sub := range subs {
+		if matched := sub.match(changed); len(matched) > 0 {
+			sub.fn(matched)
+		}
+	}
+}
+
+func (s *configSubscription) match(changed []string) []string {
+	if len(s.keys) == 0 {
+		return changed
+	}
+	var matched []string
+	for _, key := range changed {
+		for _, k := range s.keys {
+			if key == k || strings.HasPrefix(key, k+".") {
+				matched = append(matched, key)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+func settingsSnapshot(v *viper.Viper) map[string]interface{} {
+	snapshot := make(map[string]interface{})
+	for _, key := range v.AllKeys() {
+		snapshot[key] = v.Get(key)
+	}
+	return snapshot
+}
+
+func changedKeys(before, after map[string]interface{}) []string {
+	var changed []string
+	for key, value := range after {
+		if old, ok := before[key]; !ok || !reflect.DeepEqual(old, value) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
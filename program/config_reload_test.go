@@ -0,0 +1,196 @@
+package program
+
+import (
+	"errors"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func loadTestConfig(t *testing.T, root string, content string) (*Config, string) {
+	t.Helper()
+	file := writeTestFile(t, filepath.Join(root, "config"), "config.toml", content)
+	c, err := LoadConfig(testFolders(root), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, file
+}
+
+func TestReloadNotifiesSubscribers(t *testing.T) {
+	root := t.TempDir()
+	c, file := loadTestConfig(t, root, "[server]\nurl = \"http://a\"\nworkers = 2\n[other]\nname = \"x\"\n")
+	r := c.NewReloader()
+
+	var serverChanges, otherChanges [][]string
+	r.Subscribe([]string{"server"}, func(changed []string) { serverChanges = append(serverChanges, changed) })
+	cancel := r.Subscribe([]string{"other.name"}, func(changed []string) { otherChanges = append(otherChanges, changed) })
+	cancel()
+
+	writeTestFile(t, filepath.Join(root, "config"), "config.toml", "[server]\nurl = \"http://b\"\nworkers = 2\nextra = 1\n[other]\nname = \"y\"\n")
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.GetString("server.url"); got != "http://b" {
+		t.Errorf("server.url = %q after reload, want http://b", got)
+	}
+	if got := c.Sub("server").GetInt("extra"); got != 1 {
+		t.Errorf("server.extra = %d after reload through a section view, want 1", got)
+	}
+	if source := c.Source("server.url"); source != file {
+		t.Errorf("source of server.url = %q, want %s", source, file)
+	}
+	want := [][]string{{"server.extra", "server.url"}}
+	if !reflect.DeepEqual(serverChanges, want) {
+		t.Errorf("server subscriber got %v, want %v", serverChanges, want)
+	}
+	if len(otherChanges) != 0 {
+		t.Errorf("cancelled subscriber got %v", otherChanges)
+	}
+
+	// an unchanged reload notifies nobody
+	serverChanges = nil
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(serverChanges) != 0 {
+		t.Errorf("unchanged reload notified %v", serverChanges)
+	}
+}
+
+func TestReloadRejected(t *testing.T) {
+	root := t.TempDir()
+	c, _ := loadTestConfig(t, root, "[server]\nurl = \"http://a\"\n")
+	r := c.NewReloader(WithReloadValidation(map[string]interface{}{"server": &testServerConfig{}}))
+	notified := false
+	r.Subscribe(nil, func(changed []string) { notified = true })
+
+	file := writeTestFile(t, filepath.Join(root, "config"), "config.toml", "[server]\nurl = \"not a url\"\n")
+	err := r.Reload()
+	var configErrors ConfigErrors
+	if !errors.As(err, &configErrors) || len(configErrors) != 1 {
+		t.Fatalf("reload of an invalid config: %v, want one ConfigError", err)
+	}
+	if configErrors[0].Source != file {
+		t.Errorf("rejection names %q, want %s", configErrors[0].Source, file)
+	}
+
+	writeTestFile(t, filepath.Join(root, "config"), "config.toml", "[server\nurl = ")
+	var fileError *ConfigFileError
+	if err = r.Reload(); !errors.As(err, &fileError) {
+		t.Fatalf("reload of a broken file: %v, want a ConfigFileError", err)
+	}
+
+	if got := c.GetString("server.url"); got != "http://a" {
+		t.Errorf("server.url = %q after rejected reloads, want http://a", got)
+	}
+	if notified {
+		t.Error("rejected reload notified subscribers")
+	}
+}
+
+func TestReloadKeepsFlagsAndDefaults(t *testing.T) {
+	root := t.TempDir()
+	c, _ := loadTestConfig(t, root, "log_level = \"info\"\n")
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("log-level", "warn", "")
+	if err := flags.Parse([]string{"--log-level=debug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.BindFlag("log_level", flags.Lookup("log-level")); err != nil {
+		t.Fatal(err)
+	}
+	c.SetDefault("workers", 4)
+
+	writeTestFile(t, filepath.Join(root, "config"), "config.toml", "log_level = \"error\"\n")
+	if err := c.NewReloader().Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.GetString("log_level"); got != "debug" {
+		t.Errorf("log_level = %q after reload, want the flag value debug", got)
+	}
+	if source := c.Source("log_level"); source != SourceFlag+":--log-level" {
+		t.Errorf("source of log_level = %q, want the flag", source)
+	}
+	if got := c.GetInt("workers"); got != 4 {
+		t.Errorf("workers = %d after reload, want the default 4", got)
+	}
+}
+
+func TestReloadWatchDebounces(t *testing.T) {
+	root := t.TempDir()
+	c, _ := loadTestConfig(t, root, "n = 0\n")
+	r := c.NewReloader(WithWatch(100 * time.Millisecond))
+	reloads := make(chan []string, 10)
+	r.Subscribe(nil, func(changed []string) { reloads <- changed })
+	r.Start()
+	defer r.Stop()
+
+	for _, content := range []string{"n = 1\n", "n = 2\n", "n = 3\n"} {
+		writeTestFile(t, filepath.Join(root, "config"), "config.toml", content)
+	}
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after the files changed")
+	}
+	if got := c.GetInt("n"); got != 3 {
+		t.Errorf("n = %d, want the last write 3", got)
+	}
+	select {
+	case changed := <-reloads:
+		t.Errorf("second reload of %v, want the writes debounced into one", changed)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	root := t.TempDir()
+	c, _ := loadTestConfig(t, root, "n = 0\n")
+	r := c.NewReloader()
+	reloads := make(chan []string, 1)
+	r.Subscribe(nil, func(changed []string) { reloads <- changed })
+	r.Start()
+	defer r.Stop()
+
+	writeTestFile(t, filepath.Join(root, "config"), "config.toml", "n = 1\n")
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload on SIGHUP")
+	}
+	if got := c.GetInt("n"); got != 1 {
+		t.Errorf("n = %d after SIGHUP, want 1", got)
+	}
+}
+
+func TestReloadGlobalConfig(t *testing.T) {
+	t.Cleanup(func() {
+		viper.Reset()
+		globalConfig = &Config{state: newConfigState(nil, true)}
+	})
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "config"), "config.toml", "[server]\nurl = \"http://a\"\n")
+	if _, err := LoadConfigsWithProfileE(testFolders(root), "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, filepath.Join(root, "config"), "config.toml", "[server]\nurl = \"http://b\"\n")
+	if err := GlobalConfig().NewReloader().Reload(); err != nil {
+		t.Fatal(err)
+	}
+	var out testServerConfig
+	if err := BindConfig("server", &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.URL != "http://b" {
+		t.Errorf("bound url %q after a global reload, want http://b", out.URL)
+	}
+}
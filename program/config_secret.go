@@ -0,0 +1,230 @@
+package program
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SecretKeyFile is the key of EncSecretProvider registered by LoadConfigs, relative to the private folder.
+const SecretKeyFile = "secret.key"
+
+// SecretProvider resolves config file values written as "<scheme>:<reference>".
+type SecretProvider interface {
+	Scheme() string
+	Resolve(reference string) (string, error)
+}
+
+var (
+	secretMu        sync.RWMutex
+	secretProviders = map[string]SecretProvider{}
+)
+
+func init() {
+	RegisterSecretProvider(FileSecretProvider{})
+	RegisterSecretProvider(EnvSecretProvider{})
+}
+
+// RegisterSecretProvider makes values of the provider scheme resolve through it, replacing any provider of the
+// same scheme.
+func RegisterSecretProvider(provider SecretProvider) {
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	secretProviders[provider.Scheme()] = provider
+}
+
+// FileSecretProvider reads "file:///run/secrets/db_pw". A trailing newline is dropped.
+type FileSecretProvider struct{}
+
+func (FileSecretProvider) Scheme() string {
+	return "file"
+}
+
+func (FileSecretProvider) Resolve(reference string) (string, error) {
+	content, err := os.ReadFile(strings.TrimPrefix(reference, "//"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// EnvSecretProvider reads "env:NAME". An unset variable is an error.
+type EnvSecretProvider struct{}
+
+func (EnvSecretProvider) Scheme() string {
+	return "env"
+}
+
+func (EnvSecretProvider) Resolve(reference string) (string, error) {
+	value, ok := os.LookupEnv(reference)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", reference)
+	}
+	return value, nil
+}
+
+// EncSecretProvider decrypts "enc:<base64>" values made by EncryptSecret. KeyFile holds the base64 encoded 32 byte
+// AES-256-GCM key and is read on first use.
+type EncSecretProvider struct {
+	KeyFile string
+}
+
+func (EncSecretProvider) Scheme() string {
+	return "enc"
+}
+
+func (p EncSecretProvider) Resolve(reference string) (string, error) {
+	key, err := ReadSecretKey(p.KeyFile)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(reference)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted value: too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value with %s: %w", p.KeyFile, err)
+	}
+	return string(plaintext), nil
+}
+
+// ReadSecretKey reads a key file of EncSecretProvider.
+func ReadSecretKey(keyFile string) ([]byte, error) {
+	content, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("secret key %s: %w", keyFile, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secret key %s: expected 32 bytes, got %d", keyFile, len(key))
+	}
+	return key, nil
+}
+
+// NewSecretKey generates a key for EncSecretProvider, base64 encoded as expected in the key file.
+func NewSecretKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// EncryptSecret produces the "enc:..." config value of plaintext.
+func EncryptSecret(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return "enc:" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// secretProvider returns the provider for a value written as a secret reference, preferring the providers
+// registered on c.
+func (c *Config) secretProvider(value string) (SecretProvider, string, bool) {
+	scheme, reference, ok := strings.Cut(value, ":")
+	if !ok {
+		return nil, "", false
+	}
+	c.state.mu.RLock()
+	provider, ok := c.state.providers[scheme]
+	c.state.mu.RUnlock()
+	if ok {
+		return provider, reference, true
+	}
+	secretMu.RLock()
+	defer secretMu.RUnlock()
+	provider, ok = secretProviders[scheme]
+	return provider, reference, ok
+}
+
+// resolveSettings replaces the secret references among the values of a config file by the secrets, in place and
+// recursing into sections and lists. Only file values are resolved: environment variables and flags are taken
+// literally. It returns the keys holding secrets; a list with a secret element counts as one secret key.
+func (c *Config) resolveSettings(file string, settings map[string]interface{}) (secretKeys []string, errs ConfigErrors) {
+	_, secretKeys, errs = c.resolveValue(file, "", settings)
+	return
+}
+
+func (c *Config) resolveValue(file string, key string, value interface{}) (interface{}, []string, ConfigErrors) {
+	switch v := value.(type) {
+	case string:
+		provider, reference, ok := c.secretProvider(v)
+		if !ok {
+			return v, nil, nil
+		}
+		secret, err := provider.Resolve(reference)
+		if err != nil {
+			return v, nil, ConfigErrors{{Key: key, Source: file, Message: fmt.Sprintf("%s secret: %s", provider.Scheme(), err)}}
+		}
+		return secret, []string{strings.ToLower(key)}, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var secretKeys []string
+		var errs ConfigErrors
+		for _, k := range keys {
+			resolved, secrets, errx := c.resolveValue(file, joinKey(key, k), v[k])
+			v[k] = resolved
+			secretKeys = append(secretKeys, secrets...)
+			errs = append(errs, errx...)
+		}
+		return v, secretKeys, errs
+	case []interface{}:
+		secret := false
+		var errs ConfigErrors
+		for i, item := range v {
+			resolved, secrets, errx := c.resolveValue(file, fmt.Sprintf("%s[%d]", key, i), item)
+			v[i] = resolved
+			secret = secret || len(secrets) > 0
+			errs = append(errs, errx...)
+		}
+		if secret {
+			return v, []string{strings.ToLower(key)}, errs
+		}
+		return v, nil, errs
+	}
+	return value, nil, nil
+}
+
+// recordSecrets remembers the keys holding secrets for redaction.
+func (c *Config) recordSecrets(keys []string) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	for _, key := range keys {
+		c.state.secretKeys[key] = true
+	}
+}
@@ -0,0 +1,149 @@
+package program
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestFile writes a file under dir, creating its folder.
+func writeTestFile(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func testFolders(root string) FolderConfig {
+	return FolderConfig{Root: root}
+}
+
+func newTestKey(t *testing.T, root string) []byte {
+	t.Helper()
+	encoded, err := NewSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writeTestFile(t, filepath.Join(root, "private"), SecretKeyFile, encoded)
+	key, err := ReadSecretKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncSecretRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	key := newTestKey(t, root)
+	value, err := EncryptSecret(key, "s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(root, "config"), "config.toml", "[db]\npassword = \""+value+"\"\n")
+
+	c, err := LoadConfig(testFolders(root), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.GetString("db.password"); got != "s3cr3t" {
+		t.Fatalf("db.password = %q, want s3cr3t", got)
+	}
+	if !c.isSecret("db.password") {
+		t.Error("db.password not recorded as a secret")
+	}
+}
+
+func TestEncSecretWrongKey(t *testing.T) {
+	root := t.TempDir()
+	newTestKey(t, root)
+	otherKey, err := NewSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := writeTestFile(t, t.TempDir(), "other.key", otherKey)
+	key, err := ReadSecretKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := EncryptSecret(key, "s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := writeTestFile(t, filepath.Join(root, "config"), "config.toml", "password = \""+value+"\"\n")
+
+	_, err = LoadConfig(testFolders(root), "", "")
+	var configErrors ConfigErrors
+	if !errors.As(err, &configErrors) || len(configErrors) != 1 {
+		t.Fatalf("load with the wrong key: %v, want one ConfigError", err)
+	}
+	if configErrors[0].Key != "password" || configErrors[0].Source != file {
+		t.Errorf("error names %s in %s, want password in %s", configErrors[0].Key, configErrors[0].Source, file)
+	}
+}
+
+func TestEncSecretTampered(t *testing.T) {
+	root := t.TempDir()
+	key := newTestKey(t, root)
+	value, err := EncryptSecret(key, "s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flip a character of the ciphertext, past the nonce
+	tampered := []byte(value)
+	i := len(tampered) - 4
+	if tampered[i] == 'A' {
+		tampered[i] = 'B'
+	} else {
+		tampered[i] = 'A'
+	}
+	provider := EncSecretProvider{KeyFile: filepath.Join(root, "private", SecretKeyFile)}
+	if _, err = provider.Resolve(strings.TrimPrefix(string(tampered), "enc:")); err == nil {
+		t.Fatal("tampered ciphertext decrypted")
+	}
+	if _, err = provider.Resolve("not base64!"); err == nil {
+		t.Fatal("malformed value decrypted")
+	}
+}
+
+func TestSecretsOnlyInFiles(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("SECRET_TEST_DB_PW", "from-env")
+	t.Setenv("SECRETTEST_PLAIN", "env:SECRET_TEST_DB_PW")
+	writeTestFile(t, filepath.Join(root, "config"), "config.toml", "password = \"env:SECRET_TEST_DB_PW\"\nplain = \"x\"\n")
+
+	c, err := LoadConfig(testFolders(root), "SECRETTEST", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.GetString("password"); got != "from-env" {
+		t.Errorf("file reference resolved to %q, want from-env", got)
+	}
+	if got := c.GetString("plain"); got != "env:SECRET_TEST_DB_PW" {
+		t.Errorf("env value = %q, want it taken literally", got)
+	}
+}
+
+func TestSecretsInLists(t *testing.T) {
+	root := t.TempDir()
+	secretFile := writeTestFile(t, root, "token", "t0k3n\n")
+	writeTestFile(t, filepath.Join(root, "config"), "config.yaml", "tokens:\n  - plain\n  - file://"+secretFile+"\n")
+
+	c, err := LoadConfig(testFolders(root), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := c.GetStringSlice("tokens")
+	if len(tokens) != 2 || tokens[0] != "plain" || tokens[1] != "t0k3n" {
+		t.Fatalf("tokens = %v, want [plain t0k3n]", tokens)
+	}
+	if !c.isSecret("tokens") {
+		t.Error("tokens not recorded as a secret")
+	}
+}
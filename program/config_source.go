@@ -0,0 +1,25 @@
+package program
+
+import (
+	"strings"
+)
+
+const (
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceDefault = "default"
+)
+
+// EnvKey is the environment variable viper consults for key, e.g. INJ_LOG_LEVEL.
+func EnvKey(prefix string, key string) string {
+	if prefix == "" {
+		return strings.ToUpper(key)
+	}
+	return strings.ToUpper(prefix + "_" + key)
+}
+
+// ConfigSource tells where the effective value of key comes from: SourceFlag, SourceEnv, the path of the config
+// file that set it last, or "" when it is not configured.
+func ConfigSource(key string) string {
+	return globalConfig.Source(key)
+}
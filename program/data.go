@@ -0,0 +1,209 @@
+package program
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PIDFile is the lock file in the data folder preventing two instances from sharing a root.
+const PIDFile = "instance.pid"
+
+// LockError reports that another process holds the lock file. PID is 0 when the file does not tell.
+type LockError struct {
+	Path string
+	PID  int
+}
+
+func (e *LockError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("%s is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("%s is locked by process %d", e.Path, e.PID)
+}
+
+// PIDLock is an exclusive lock on a file in the data folder, held until Release or the exit of the process.
+// The file holds the pid of the owner for information only.
+type PIDLock struct {
+	file *os.File
+}
+
+// AcquirePIDLock locks <dataFolder>/instance.pid and writes the pid of this process into it. It returns a
+// *LockError while another process holds the lock. The kernel releases the lock whatever way the process exits,
+// so a file left behind by a crash does not block a restart.
+func AcquirePIDLock(dataFolder string) (*PIDLock, error) {
+	lockPath := path.Join(dataFolder, PIDFile)
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := tryLockFile(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if !locked {
+		pid, _ := readPID(lockPath)
+		_ = f.Close()
+		return nil, &LockError{Path: lockPath, PID: pid}
+	}
+
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &PIDLock{file: f}, nil
+}
+
+func readPID(lockPath string) (int, error) {
+	content, err := os.ReadFile(lockPath)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
+
+// Path is the path of the lock file.
+func (l *PIDLock) Path() string {
+	return l.file.Name()
+}
+
+// Release clears the pid and releases the lock. The file stays, removing it would race with a process locking it.
+func (l *PIDLock) Release() error {
+	_ = l.file.Truncate(0)
+	return l.file.Close()
+}
+
+// WriteFileAtomic writes data to a temporary file next to name and renames it over name, so readers see either
+// the old or the new content, also after a crash.
+func WriteFileAtomic(name string, data []byte, perm os.FileMode) (err error) {
+	dir := filepath.Dir(name)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir persists a rename. Not every platform can sync a directory, so failures are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+const snapshotTimeFormat = "20060102T150405.000000000Z"
+
+// SnapshotStore keeps the latest snapshots of some state as <name>-<utc time>.snap files in a folder,
+// typically under the data folder.
+type SnapshotStore struct {
+	folder string
+	name   string
+	keep   int
+}
+
+// NewSnapshotStore keeps the keep newest snapshots of name in folder, creating folder if needed. keep < 1 keeps one.
+func NewSnapshotStore(folder string, name string, keep int) (*SnapshotStore, error) {
+	err := mkDirPermIfNotExists(folder, 0755)
+	if err != nil {
+		return nil, err
+	}
+	if keep < 1 {
+		keep = 1
+	}
+	return &SnapshotStore{folder: folder, name: name, keep: keep}, nil
+}
+
+// Save writes a new snapshot atomically and removes the snapshots beyond keep. It returns the path written.
+func (s *SnapshotStore) Save(data []byte) (string, error) {
+	snapshotPath := path.Join(s.folder, s.name+"-"+time.Now().UTC().Format(snapshotTimeFormat)+".snap")
+	err := WriteFileAtomic(snapshotPath, data, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	snapshots, err := s.List()
+	if err != nil {
+		return snapshotPath, err
+	}
+	for len(snapshots) > s.keep {
+		err = os.Remove(snapshots[0])
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return snapshotPath, err
+		}
+		snapshots = snapshots[1:]
+	}
+	return snapshotPath, nil
+}
+
+// List returns the paths of the snapshots, oldest first.
+func (s *SnapshotStore) List() ([]string, error) {
+	entries, err := os.ReadDir(s.folder)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, s.name+"-") || !strings.HasSuffix(name, ".snap") {
+			continue
+		}
+		if _, err := time.Parse(snapshotTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, s.name+"-"), ".snap")); err != nil {
+			continue
+		}
+		result = append(result, path.Join(s.folder, name))
+	}
+	// the time format sorts lexically
+	sort.Strings(result)
+	return result, nil
+}
+
+// Latest reads the newest snapshot. It returns os.ErrNotExist when there is none.
+func (s *SnapshotStore) Latest() (data []byte, snapshotPath string, err error) {
+	snapshots, err := s.List()
+	if err != nil {
+		return nil, "", err
+	}
+	if len(snapshots) == 0 {
+		return nil, "", fmt.Errorf("no snapshot of %s in %s: %w", s.name, s.folder, os.ErrNotExist)
+	}
+	snapshotPath = snapshots[len(snapshots)-1]
+	data, err = os.ReadFile(snapshotPath)
+	return data, snapshotPath, err
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package program
+
+import (
+	"github.com/rs/zerolog/log"
+	"os"
+)
+
+// tryLockFile cannot lock on this platform, so instances sharing a root are not detected.
+func tryLockFile(f *os.File) (bool, error) {
+	log.Warn().Str("path", f.Name()).Msg("file locking is not supported on this platform")
+	return true, nil
+}
@@ -0,0 +1,124 @@
+package program
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPIDLock(t *testing.T) {
+	dir := t.TempDir()
+	lock, err := AcquirePIDLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(lock.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := strings.TrimSpace(string(content)); pid != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file holds %q, want the pid %d", pid, os.Getpid())
+	}
+
+	// flock locks belong to the open file, so a second acquire fails even in this process
+	_, err = AcquirePIDLock(dir)
+	var lockError *LockError
+	if !errors.As(err, &lockError) {
+		t.Fatalf("second acquire: %v, want a LockError", err)
+	}
+	if lockError.PID != os.Getpid() || lockError.Path != filepath.Join(dir, PIDFile) {
+		t.Errorf("lock error %v, want pid %d of %s", lockError, os.Getpid(), filepath.Join(dir, PIDFile))
+	}
+
+	if err = lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = AcquirePIDLock(dir)
+	if err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	_ = lock.Release()
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "state.json")
+	for _, content := range []string{"first", "second"} {
+		if err := WriteFileAtomic(name, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("file holds %q, want %q", got, content)
+		}
+	}
+	if info, _ := os.Stat(name); info.Mode().Perm() != 0600 {
+		t.Errorf("file mode %s, want 0600", info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("folder holds %d entries, want no temporary file left", len(entries))
+	}
+
+	if err = WriteFileAtomic(filepath.Join(dir, "missing", "state.json"), nil, 0600); err == nil {
+		t.Error("wrote into a missing folder")
+	}
+}
+
+func TestSnapshotStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	store, err := NewSnapshotStore(dir, "orders", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = store.Latest(); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("latest of an empty store: %v, want ErrNotExist", err)
+	}
+	// files of other names and other shapes are left alone
+	for name, content := range map[string]string{
+		"other-20240101T000000.000000000Z.snap": "other",
+		"orders-latest.snap":                    "not a snapshot",
+	} {
+		if err = os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var saved []string
+	for _, content := range []string{"1", "2", "3"} {
+		snapshotPath, err := store.Save([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, snapshotPath)
+	}
+	snapshots, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0] != saved[1] || snapshots[1] != saved[2] {
+		t.Fatalf("snapshots = %v, want the two newest %v", snapshots, saved[1:])
+	}
+	data, snapshotPath, err := store.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("3")) || snapshotPath != saved[2] {
+		t.Errorf("latest = %q from %s, want 3 from %s", data, snapshotPath, saved[2])
+	}
+	for _, name := range []string{"other-20240101T000000.000000000Z.snap", "orders-latest.snap"} {
+		if _, err = os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s removed: %v", name, err)
+		}
+	}
+}
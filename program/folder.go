@@ -1,8 +1,11 @@
 package program
 
 import (
+	"fmt"
+	"github.com/rs/zerolog/log"
 	"os"
 	"path"
+	"path/filepath"
 )
 
 type FolderConfig struct {
@@ -13,6 +16,21 @@ type FolderConfig struct {
 	Private string
 }
 
+// FolderError reports a folder that could not be created.
+type FolderError struct {
+	Name string
+	Path string
+	Err  error
+}
+
+func (e *FolderError) Error() string {
+	return fmt.Sprintf("%s folder %s: %v", e.Name, e.Path, e.Err)
+}
+
+func (e *FolderError) Unwrap() error {
+	return e.Err
+}
+
 func mkDirPermIfNotExists(path string, perm os.FileMode) error {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -25,12 +43,12 @@ func mkDirPermIfNotExists(path string, perm os.FileMode) error {
 	return os.MkdirAll(path, perm)
 }
 
-func ensureFolder(folder string, perm os.FileMode) {
+func ensureFolder(name string, folder string, perm os.FileMode) error {
 	err := mkDirPermIfNotExists(folder, perm)
 	if err != nil {
-		panic(err)
+		return &FolderError{Name: name, Path: folder, Err: err}
 	}
-	return
+	return nil
 }
 
 func defaultPath(givenPath string, defaultRoot string, suffix string) string {
@@ -44,18 +62,81 @@ func defaultPath(givenPath string, defaultRoot string, suffix string) string {
 }
 
 func EnsureFolders(config FolderConfig) FolderConfig {
-	config = FolderConfig{
-		Root:    config.Root,
-		Log:     defaultPath(config.Log, config.Root, "log"),
-		Data:    defaultPath(config.Data, config.Root, "data"),
-		Config:  defaultPath(config.Config, config.Root, "config"),
-		Private: defaultPath(config.Private, config.Root, "private"),
-	}
-	ensureFolder(config.Root, 0755)
-	ensureFolder(config.Log, 0755)
-	ensureFolder(config.Data, 0755)
-	ensureFolder(config.Config, 0755)
-	ensureFolder(config.Private, 0700)
+	config, err := EnsureFoldersE(config)
+	if err != nil {
+		panic(err)
+	}
 	return config
+}
 
+// checkWritable creates and removes a file in folder.
+func checkWritable(name string, folder string) error {
+	f, err := os.CreateTemp(folder, ".write-check-*")
+	if err != nil {
+		return &FolderError{Name: name, Path: folder, Err: fmt.Errorf("not writable: %w", err)}
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return nil
+}
+
+// warnIfShared warns when others than the owner may access folder.
+func warnIfShared(name string, folder string) {
+	info, err := os.Stat(folder)
+	if err != nil {
+		return
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		log.Warn().Str("folder", name).Str("path", folder).Str("mode", info.Mode().Perm().String()).
+			Msg("folder is accessible by group or others, expected 0700")
+	}
+}
+
+// EnsureFoldersE is EnsureFolders returning a *FolderError instead of panicking.
+//
+// An empty or relative Root is taken from the working directory, so every folder is absolute. Log and Data must
+// be writable; Config and Private may be read-only mounts. A Private folder accessible by group or others is
+// logged as a warning.
+func EnsureFoldersE(config FolderConfig) (FolderConfig, error) {
+	root, err := filepath.Abs(config.Root)
+	if err != nil {
+		return config, &FolderError{Name: "root", Path: config.Root, Err: err}
+	}
+	config = FolderConfig{
+		Root:    root,
+		Log:     defaultPath(config.Log, root, "log"),
+		Data:    defaultPath(config.Data, root, "data"),
+		Config:  defaultPath(config.Config, root, "config"),
+		Private: defaultPath(config.Private, root, "private"),
+	}
+	for _, folder := range []struct {
+		name string
+		path string
+		perm os.FileMode
+	}{
+		{"root", config.Root, 0755},
+		{"log", config.Log, 0755},
+		{"data", config.Data, 0755},
+		{"config", config.Config, 0755},
+		{"private", config.Private, 0700},
+	} {
+		err = ensureFolder(folder.name, folder.path, folder.perm)
+		if err != nil {
+			return config, err
+		}
+	}
+	for _, folder := range []struct {
+		name string
+		path string
+	}{
+		{"log", config.Log},
+		{"data", config.Data},
+	} {
+		err = checkWritable(folder.name, folder.path)
+		if err != nil {
+			return config, err
+		}
+	}
+	warnIfShared("private", config.Private)
+	return config, nil
 }
@@ -0,0 +1,66 @@
+package program
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsureFolders(t *testing.T) {
+	root := t.TempDir()
+	folders, err := EnsureFoldersE(FolderConfig{Root: root, Data: "state", Log: filepath.Join(root, "logs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FolderConfig{
+		Root:    root,
+		Log:     filepath.Join(root, "logs"),
+		Data:    filepath.Join(root, "state"),
+		Config:  filepath.Join(root, "config"),
+		Private: filepath.Join(root, "private"),
+	}
+	if folders != want {
+		t.Fatalf("folders = %+v, want %+v", folders, want)
+	}
+	for _, folder := range []string{want.Log, want.Data, want.Config, want.Private} {
+		if info, err := os.Stat(folder); err != nil || !info.IsDir() {
+			t.Errorf("folder %s not created: %v", folder, err)
+		}
+	}
+	if info, _ := os.Stat(want.Private); info.Mode().Perm() != 0700 {
+		t.Errorf("private folder mode %s, want 0700", info.Mode().Perm())
+	}
+}
+
+func TestEnsureFoldersRelativeRoot(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	folders, err := EnsureFoldersE(FolderConfig{Root: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "app"); folders.Root != want {
+		t.Errorf("root = %s, want %s", folders.Root, want)
+	}
+}
+
+func TestEnsureFoldersNotWritable(t *testing.T) {
+	root := t.TempDir()
+	// a file where the data folder should be
+	if err := os.WriteFile(filepath.Join(root, "data"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := EnsureFoldersE(FolderConfig{Root: root})
+	var folderError *FolderError
+	if !errors.As(err, &folderError) || folderError.Name != "data" {
+		t.Fatalf("ensure with a file as data folder: %v, want a FolderError of data", err)
+	}
+}